@@ -0,0 +1,146 @@
+package main
+
+// Explain effective permissions of a path, and the rules deciding them.
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+	"github.com/gorilla/mux"
+)
+
+type accessExplanation struct {
+	Path          string                    `json:"path"`
+	User          *UserInfo                 `json:"user"`             // nil if anonymous
+	Groups        []string                  `json:"groups,omitempty"` // groups the user is in
+	Files         []string                  `json:"files"`            // ".ghs.yml" and ".ghsignore" applied, from root
+	Access        accessDecision            `json:"access"`           // accessTables allowing the path, denied paths are not found
+	LoginRequired bool                      `json:"loginRequired"`
+	Permissions   map[string]accessDecision `json:"permissions"`
+	Warnings      []string                  `json:"warnings,omitempty"`
+}
+
+// accessConfSources returns the ".ghs.yml" and ".ghsignore" files from root to directory dir,
+// and which ".ghs.yml" the value of each key comes from
+func (s *HTTPStaticServer) accessConfSources(dir string) (files []string, sources map[string]string) {
+	sources = make(map[string]string)
+	dirs := []string{"."}
+	if dir != "." {
+		parts := strings.Split(filepath.ToSlash(dir), "/")
+		for i := range parts {
+			dirs = append(dirs, strings.Join(parts[:i+1], "/"))
+		}
+	}
+	for _, dir := range dirs {
+		cfgName := filepath.ToSlash(filepath.Join(dir, ".ghs.yml"))
+		data, err := ioutil.ReadFile(filepath.Join(s.Root, cfgName))
+		var values map[string]interface{}
+		var conf AccessConf
+		// a malformed file is not applied, it is only reported in warnings
+		if err == nil && yaml.Unmarshal(data, &values) == nil && yaml.Unmarshal(data, &conf) == nil {
+			files = append(files, cfgName)
+			for key, value := range values {
+				// login required by a parent directory could not be unset
+				if key == "loginRequired" && (value != true || sources[key] != "") {
+					continue
+				}
+				sources[key] = cfgName
+			}
+		}
+		ignoreName := filepath.ToSlash(filepath.Join(dir, ignoreFileName))
+		if _, err := os.Stat(filepath.Join(s.Root, ignoreName)); err == nil {
+			files = append(files, ignoreName)
+		}
+	}
+	return
+}
+
+// memberGroups returns names of groups user is in, from identity provider and server config
+func (c *AccessConf) memberGroups(user *UserInfo) []string {
+	if user == nil {
+		return nil
+	}
+	groups := append([]string{}, user.Groups...)
+	for name := range c.groupMembers {
+		if c.inGroup(&UserInfo{Email: user.Email}, name) {
+			groups = append(groups, name)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// hAccess explains permissions of path for the current user, or the user of email "user".
+// Groups of identity provider are only known for the current user.
+func (s *HTTPStaticServer) hAccess(w http.ResponseWriter, req *http.Request) {
+	path := filepath.ToSlash(filepath.Clean(strings.TrimPrefix(mux.Vars(req)["path"], "/")))
+	// the rules are readable as ".ghs.yml" files by anyone who could list the directory
+	if !s.checkRead(w, req, filepath.Dir(path)) {
+		return
+	}
+	// like everywhere else, paths hidden by ignore or accessTables do not exist
+	access, accessDir := s.accessibleDecision(path)
+	if !access.Allowed {
+		http.NotFound(w, req)
+		return
+	}
+	user := currentUser(req)
+	if email := req.FormValue("user"); email != "" && (user == nil || email != user.Email) {
+		user = &UserInfo{Email: email}
+	}
+	dir := s.accessConfDir(path)
+	auth, _ := s.dirAccessConf(dir)
+	files, sources := s.accessConfSources(dir)
+	source := func(key string) string {
+		if v, ok := sources[key]; ok {
+			return v
+		}
+		return "server"
+	}
+
+	switch access.Rule {
+	case "":
+		access.Rule, access.Source = "default", "server"
+	case "accessTables":
+		_, accessSources := s.accessConfSources(accessDir)
+		access.Source = accessSources["accessTables"]
+	}
+	permissions := make(map[string]accessDecision)
+	for _, action := range []string{actionRead, actionUpload, actionDelete, actionMKDir} {
+		var decision accessDecision
+		if action == actionRead {
+			decision = auth.readDecision(user)
+		} else {
+			decision = auth.decision(user, action)
+		}
+		switch decision.Rule {
+		case "user":
+			decision.Source = source("users")
+		case "group":
+			decision.Source = source("groups")
+		case "loginRequired":
+			decision.Source = source("loginRequired")
+		default:
+			decision.Source = source(action)
+		}
+		permissions[action] = decision
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	json.NewEncoder(w).Encode(accessExplanation{
+		Path:          path,
+		User:          user,
+		Groups:        auth.memberGroups(user),
+		Files:         files,
+		Access:        access,
+		LoginRequired: auth.LoginRequired && !auth.basicAuth,
+		Permissions:   permissions,
+		Warnings:      auth.Warnings,
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAccessExplanation(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "a"), 0755)
+	os.MkdirAll(filepath.Join(root, "secret"), 0755)
+	ioutil.WriteFile(filepath.Join(root, ".ghs.yml"), []byte("upload: true\n"), 0644)
+	ioutil.WriteFile(filepath.Join(root, ignoreFileName), []byte("secret/\n"), 0644)
+	// decoded as yaml, but not as conf
+	ioutil.WriteFile(filepath.Join(root, "a", ".ghs.yml"), []byte("upload: false\nread: [1]\n"), 0644)
+	s := NewHTTPStaticServer(root)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/-/access/secret", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Failed: hidden path - code:%d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/-/access/a", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed: code:%d %s", w.Code, w.Body.String())
+	}
+	var res accessExplanation
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if files := []string{".ghs.yml", ignoreFileName}; !reflect.DeepEqual(res.Files, files) {
+		t.Fatalf("Failed: files:%v", res.Files)
+	}
+	if upload := res.Permissions[actionUpload]; !upload.Allowed || upload.Source != ".ghs.yml" {
+		t.Fatalf("Failed: upload:%+v", upload)
+	}
+	if len(res.Warnings) == 0 {
+		t.Fatal("Failed: malformed a/.ghs.yml not warned")
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+// Reading and replacing ".ghs.yml" of directories as JSON by admins, validated before written.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+	"github.com/gorilla/mux"
+)
+
+// validEmail reports whether email is a bare address like a@example.com
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email && !strings.Contains(email, "*")
+}
+
+// validMember reports whether member of group or admins is an email or "*@domain"
+func validMember(member string) bool {
+	if strings.HasPrefix(member, "*@") {
+		return validEmail("x" + member[1:])
+	}
+	return validEmail(member)
+}
+
+// isAdmin reports whether user could edit ".ghs.yml" of every directory
+func (s *HTTPStaticServer) isAdmin(user *UserInfo) bool {
+	return user != nil && memberMatch(s.Admins, user.Email)
+}
+
+// checkAdmin reports whether the user of request is admin, otherwise it responds 401 or 403
+func (s *HTTPStaticServer) checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	user := currentUser(r)
+	if s.isAdmin(user) {
+		return true
+	}
+	if user == nil {
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return false
+	}
+	http.Error(w, "ACL forbidden: not admin", http.StatusForbidden)
+	return false
+}
+
+// checkAccessConfWrite returns 403 if file path is ".ghs.yml", ".ghsignore" or the channel history and the user of
+// request is not admin, others could not change access or rewrite history by writing, moving or removing them
+func (s *HTTPStaticServer) checkAccessConfWrite(req *http.Request, path string) error {
+	if (isAccessConfFile(path) || filepath.Base(path) == channelHistoryFile) && !s.isAdmin(currentUser(req)) {
+		return newStatusError(http.StatusForbidden, "ACL forbidden: only admins could change %s", filepath.ToSlash(path))
+	}
+	return nil
+}
+
+// yamlKey returns the key of struct field in yaml, empty if not decoded
+func yamlKey(field reflect.StructField) string {
+	if field.PkgPath != "" {
+		return ""
+	}
+	key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if key == "" {
+		key = strings.ToLower(field.Name)
+	}
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
+// confValue converts JSON value to be written as yaml of type t, objects of structs are
+// ordered like fields. Keys not in type t are reported as error.
+func confValue(value interface{}, t reflect.Type, at string) (interface{}, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return value, nil // reported when decoded
+		}
+		var items yaml.MapSlice
+		known := make(map[string]bool)
+		for i := 0; i < t.NumField(); i++ {
+			key := yamlKey(t.Field(i))
+			if key == "" {
+				continue
+			}
+			known[key] = true
+			v, ok := object[key]
+			if !ok {
+				continue
+			}
+			v, err := confValue(v, t.Field(i).Type, at+key)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, yaml.MapItem{Key: key, Value: v})
+		}
+		for key := range object {
+			if !known[key] {
+				return nil, fmt.Errorf("unknown key %s", at+key)
+			}
+		}
+		return items, nil
+	case reflect.Slice:
+		list, ok := value.([]interface{})
+		if !ok {
+			return value, nil
+		}
+		values := make([]interface{}, len(list))
+		for i, v := range list {
+			v, err := confValue(v, t.Elem(), fmt.Sprintf("%s[%d].", strings.TrimSuffix(at, "."), i))
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return values, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// yaml would truncate it silently
+		if f, ok := value.(float64); ok && f != math.Trunc(f) {
+			return nil, fmt.Errorf("%s should be an integer", at)
+		}
+	}
+	return value, nil
+}
+
+// validateAccessConf checks values of conf which yaml could decode but the server could not use
+func validateAccessConf(conf *AccessConf) error {
+	if conf.OnConflict != "" && conflictLevel(conf.OnConflict) < 0 {
+		return fmt.Errorf("unknown onConflict %q", conf.OnConflict)
+	}
+	switch conf.Checkout {
+	case "", checkoutLatest, checkoutSemver:
+	default:
+		return fmt.Errorf("unknown checkout %q", conf.Checkout)
+	}
+	if conf.Rollout < 0 || conf.Rollout > 100 {
+		return fmt.Errorf("rollout %d should be between 0 and 100", conf.Rollout)
+	}
+	if conf.HistoryKeep < -1 || conf.HistoryDays < 0 {
+		return fmt.Errorf("historyKeep should be -1 or more, historyDays 0 or more")
+	}
+	for i, user := range conf.Users {
+		if !validEmail(user.Email) {
+			return fmt.Errorf("users[%d].email %q is not an email", i, user.Email)
+		}
+	}
+	for i, group := range conf.Groups {
+		if group.Name == "" {
+			return fmt.Errorf("groups[%d].name is empty", i)
+		}
+	}
+	for i, table := range conf.AccessTables {
+		if _, err := regexp.Compile(table.Regex); err != nil {
+			return fmt.Errorf("accessTables[%d].regex: %v", i, err)
+		}
+		switch table.Match {
+		case "", "name", "path":
+		default:
+			return fmt.Errorf("accessTables[%d].match %q should be name or path", i, table.Match)
+		}
+	}
+	for _, line := range conf.Ignore {
+		if _, _, err := parseIgnorePattern(line); err != nil {
+			return fmt.Errorf("ignore %q: %v", line, err)
+		}
+	}
+	return nil
+}
+
+// parseAccessConf validates JSON conf, and returns it as content of ".ghs.yml"
+func parseAccessConf(data []byte) ([]byte, error) {
+	var object map[string]interface{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, fmt.Errorf("should be a JSON object: %v", err)
+	}
+	if object == nil {
+		return nil, fmt.Errorf("should be a JSON object")
+	}
+	value, err := confValue(object, reflect.TypeOf(AccessConf{}), "")
+	if err != nil {
+		return nil, err
+	}
+	if data, err = yaml.Marshal(value); err != nil {
+		return nil, err
+	}
+	var conf AccessConf
+	if err = yaml.Unmarshal(data, &conf); err != nil {
+		return nil, err
+	}
+	if err = validateAccessConf(&conf); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// jsonValue converts maps decoded by yaml to be encoded as JSON
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			object[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return object
+	case []interface{}:
+		for i, item := range v {
+			v[i] = jsonValue(item)
+		}
+	}
+	return value
+}
+
+// aclDir returns directory path (from root) of request, and responds 404 if it is not a directory
+func (s *HTTPStaticServer) aclDir(w http.ResponseWriter, req *http.Request) (string, bool) {
+	path := filepath.ToSlash(filepath.Clean(strings.TrimPrefix(mux.Vars(req)["path"], "/")))
+	if !isDir(filepath.Join(s.Root, path)) {
+		http.NotFound(w, req)
+		return path, false
+	}
+	return path, true
+}
+
+// hACL responds the ".ghs.yml" of directory as JSON, with the yaml if it could not be decoded
+func (s *HTTPStaticServer) hACL(w http.ResponseWriter, req *http.Request) {
+	if !s.checkAdmin(w, req) {
+		return
+	}
+	path, ok := s.aclDir(w, req)
+	if !ok {
+		return
+	}
+	cfgFile := filepath.Join(s.Root, path, ".ghs.yml")
+	data, err := ioutil.ReadFile(cfgFile)
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := map[string]interface{}{
+		"path":   path,
+		"exists": err == nil,
+		"conf":   map[string]interface{}{},
+	}
+	var conf interface{}
+	if err = yaml.Unmarshal(data, &conf); err != nil {
+		res["error"] = err.Error()
+		res["yaml"] = string(data)
+	} else if conf != nil {
+		res["conf"] = jsonValue(conf)
+	}
+	auth, _ := s.dirAccessConf(path)
+	res["warnings"] = auth.Warnings
+	setETag(w, cfgFile)
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	json.NewEncoder(w).Encode(res)
+}
+
+// hACLUpdate replaces the ".ghs.yml" of directory with the JSON body, removes it if body is an empty object.
+// If-Match with the etag of hACL keeps changes of others from being overwritten.
+func (s *HTTPStaticServer) hACLUpdate(w http.ResponseWriter, req *http.Request) {
+	if !s.checkAdmin(w, req) {
+		return
+	}
+	path, ok := s.aclDir(w, req)
+	if !ok {
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := parseAccessConf(body)
+	if err != nil {
+		http.Error(w, "Invalid conf: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	cfgPath := filepath.Join(path, ".ghs.yml")
+	cfgFile := filepath.Join(s.Root, cfgPath)
+	user, ip := requestAuthor(req)
+	fileWriteMu.Lock()
+	err = checkPreconditions(cfgFile, req.Header)
+	if err == nil {
+		err = s.saveHistory(cfgPath, "acl", user, ip)
+	}
+	if err == nil {
+		if strings.TrimSpace(string(data)) == "{}" {
+			if err = os.Remove(cfgFile); os.IsNotExist(err) {
+				err = nil
+			}
+			invalidateAccessConf()
+		} else {
+			err = writeAccessConf(filepath.Join(s.Root, path), data)
+		}
+	}
+	fileWriteMu.Unlock()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	setETag(w, cfgFile)
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
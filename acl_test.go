@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseAccessConf(t *testing.T) {
+	tests := []struct {
+		data string
+		pass bool
+	}{
+		{`{"upload": true, "users": [{"email": "a@x.com", "delete": true}]}`, true},
+		{`{"accessTables": [{"regex": "\\.log$", "match": "path"}], "ignore": ["build/"]}`, true},
+		{`{}`, true},
+		{`[]`, false},
+		{`{"uploads": true}`, false},
+		{`{"users": [{"email": "a@x.com", "admin": true}]}`, false},
+		{`{"users": [{"email": "Bob <a@x.com>"}]}`, false},
+		{`{"upload": "yes"}`, false},
+		{`{"rollout": 50.5}`, false},
+		{`{"accessTables": [{"regex": "(a"}]}`, false},
+		{`{"onConflict": "replace"}`, false},
+		{`{"warnings": ["a"]}`, false},
+	}
+	for _, v := range tests {
+		if _, err := parseAccessConf([]byte(v.data)); (err == nil) != v.pass {
+			t.Fatalf("Failed: %v - err:%v", v, err)
+		}
+	}
+}
+
+func TestAccessConfWriteForbidden(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "a"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "a", ".ghs.yml"), []byte("upload: true\n"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "a", channelHistoryFile), []byte("- action: promote\n"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "f.txt"), []byte("f"), 0644)
+	s := NewHTTPStaticServer(root)
+	s.Upload, s.Delete = true, true
+
+	upload := &bytes.Buffer{}
+	mw := multipart.NewWriter(upload)
+	part, _ := mw.CreateFormFile("file", ".ghsignore")
+	part.Write([]byte("*\n"))
+	mw.Close()
+
+	tests := []struct {
+		method string
+		url    string
+		body   string
+		code   int
+	}{
+		{"PUT", "/.ghs.yml", "upload: true\n", http.StatusForbidden},
+		{"PUT", "/a/" + channelHistoryFile, "[]\n", http.StatusForbidden},
+		{"DELETE", "/a/" + channelHistoryFile, "", http.StatusForbidden},
+		{"DELETE", "/a/.ghs.yml", "", http.StatusForbidden},
+		{"POST", "/-/move", "src=a/.ghs.yml&dst=b.yml", http.StatusForbidden},
+		{"POST", "/-/copy", "src=f.txt&dst=.ghsignore", http.StatusForbidden},
+		{"POST", "/-/batch", `{"operations": [{"op": "delete", "path": "a/.ghs.yml"}]}`, http.StatusForbidden},
+		{"POST", "/", upload.String(), http.StatusForbidden},
+		{"PUT", "/g.txt", "g", http.StatusCreated},
+	}
+	for _, v := range tests {
+		req := httptest.NewRequest(v.method, v.url, strings.NewReader(v.body))
+		switch v.url {
+		case "/":
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+		case "/-/move", "/-/copy":
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != v.code {
+			t.Fatalf("Failed: %s %s - code:%d %s", v.method, v.url, w.Code, w.Body.String())
+		}
+	}
+	for _, name := range []string{".ghs.yml", ".ghsignore", "b.yml"} {
+		if _, err := os.Lstat(filepath.Join(root, name)); err == nil {
+			t.Fatalf("Failed: %s written", name)
+		}
+	}
+	for _, name := range []string{".ghs.yml", channelHistoryFile} {
+		if !isFile(filepath.Join(root, "a", name)) {
+			t.Fatalf("Failed: a/%s removed", name)
+		}
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(root, "a", channelHistoryFile)); string(data) != "- action: promote\n" {
+		t.Fatalf("Failed: channel history changed: %q", data)
+	}
+}
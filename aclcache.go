@@ -0,0 +1,191 @@
+package main
+
+// Cache of access confs by directory, validated by stat of ".ghs.yml" and ".ghsignore" of the directory and its parents.
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// accessConfCheckInterval is how long a cached conf is used without checking files again,
+// changes by the server itself are seen immediately
+const accessConfCheckInterval = time.Second
+
+// aclGeneration is increased when the server changes files affecting access confs, see invalidateAccessConf
+var aclGeneration uint64
+
+// invalidateAccessConf drops cached access confs, it should be called after writing ".ghs.yml" or ".ghsignore",
+// or moving and removing directories
+func invalidateAccessConf() {
+	atomic.AddUint64(&aclGeneration, 1)
+}
+
+// isAccessConfFile reports whether file path affects access confs
+func isAccessConfFile(path string) bool {
+	name := filepath.Base(path)
+	return name == ".ghs.yml" || name == ignoreFileName
+}
+
+var (
+	reCache   = make(map[string]*regexp.Regexp)
+	reCacheMu sync.Mutex
+)
+
+// compileRegex returns the compiled expr, nil if invalid
+func compileRegex(expr string) *regexp.Regexp {
+	reCacheMu.Lock()
+	defer reCacheMu.Unlock()
+	pattern, ok := reCache[expr]
+	if !ok {
+		pattern, _ = regexp.Compile(expr)
+		reCache[expr] = pattern
+	}
+	return pattern
+}
+
+func cloneBool(b *bool) *bool {
+	if b == nil {
+		return nil
+	}
+	v := *b
+	return &v
+}
+
+func cloneStrings(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(list)), list...)
+}
+
+// cloneAccessConf returns a copy of c sharing no slices or pointers with it,
+// yaml decodes into the values kept and callers may change what they get
+func cloneAccessConf(c AccessConf) AccessConf {
+	c.Read = cloneBool(c.Read)
+	if c.Users != nil {
+		users := make([]UserControl, len(c.Users))
+		for i, user := range c.Users {
+			user.Read = cloneBool(user.Read)
+			users[i] = user
+		}
+		c.Users = users
+	}
+	if c.Groups != nil {
+		groups := make([]GroupControl, len(c.Groups))
+		for i, group := range c.Groups {
+			group.Read = cloneBool(group.Read)
+			groups[i] = group
+		}
+		c.Groups = groups
+	}
+	if c.AccessTables != nil {
+		c.AccessTables = append(make([]AccessTable, 0, len(c.AccessTables)), c.AccessTables...)
+	}
+	c.Ignore = cloneStrings(c.Ignore)
+	c.Warnings = cloneStrings(c.Warnings)
+	// rules are not changed once parsed, only the list is copied
+	if c.ignores != nil {
+		c.ignores = append(make([]*ignoreRules, 0, len(c.ignores)), c.ignores...)
+	}
+	return c
+}
+
+type accessConfEntry struct {
+	conf          AccessConf
+	version       uint64
+	parentVersion uint64
+	files         [2]os.FileInfo // ".ghs.yml" and ".ghsignore", nil if not exists
+	checked       time.Time
+}
+
+type accessConfCache struct {
+	mu         sync.Mutex
+	entries    map[string]*accessConfEntry // by directory from root
+	generation uint64
+	version    uint64
+}
+
+func statAccessConfFiles(dir string) (files [2]os.FileInfo) {
+	for i, name := range []string{".ghs.yml", ignoreFileName} {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			files[i] = info
+		}
+	}
+	return
+}
+
+func sameFileStat(a, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
+
+// lookup returns the cached entry of dir, fresh is true if it could be used without checking
+func (c *accessConfCache) lookup(dir string) (entry *accessConfEntry, fresh bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen := atomic.LoadUint64(&aclGeneration); gen != c.generation || c.entries == nil {
+		c.entries = make(map[string]*accessConfEntry)
+		c.generation = gen
+	}
+	entry = c.entries[dir]
+	if entry == nil {
+		return nil, false
+	}
+	return entry, time.Since(entry.checked) < accessConfCheckInterval
+}
+
+func (c *accessConfCache) store(dir string, entry *accessConfEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version++
+	entry.version = c.version
+	c.entries[dir] = entry
+}
+
+func (c *accessConfCache) touch(entry *accessConfEntry) {
+	c.mu.Lock()
+	entry.checked = time.Now()
+	c.mu.Unlock()
+}
+
+// dirAccessConf returns conf of directory dir (from root), which is cached and copied for each caller.
+// The version changes whenever the conf is built again.
+func (s *HTTPStaticServer) dirAccessConf(dir string) (AccessConf, uint64) {
+	entry, fresh := s.aclCache.lookup(dir)
+	if fresh {
+		return cloneAccessConf(entry.conf), entry.version
+	}
+	var parent AccessConf
+	var parentVersion uint64
+	if dir == "." {
+		parent = s.defaultAccessConf()
+	} else {
+		parent, parentVersion = s.dirAccessConf(filepath.Dir(dir))
+	}
+	localDir := filepath.Join(s.Root, dir)
+	files := statAccessConfFiles(localDir)
+	if entry != nil && entry.parentVersion == parentVersion &&
+		sameFileStat(entry.files[0], files[0]) && sameFileStat(entry.files[1], files[1]) {
+		s.aclCache.touch(entry)
+		return cloneAccessConf(entry.conf), entry.version
+	}
+	conf := layerAccessConf(parent, s.Root, dir)
+	// directories not exist are not cached, they could be any request path
+	if !isDir(localDir) {
+		return conf, 0
+	}
+	entry = &accessConfEntry{
+		conf:          cloneAccessConf(conf),
+		parentVersion: parentVersion,
+		files:         files,
+		checked:       time.Now(),
+	}
+	s.aclCache.store(dir, entry)
+	return conf, entry.version
+}
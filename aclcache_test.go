@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestAccessConfCache(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "a/b"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "a/.ghs.yml"), []byte("upload: true\n"), 0644)
+	s := &HTTPStaticServer{Root: root}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if conf := s.readAccessConf("a/b"); !conf.Upload {
+					t.Error("upload should be inherited from a")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	ioutil.WriteFile(filepath.Join(root, "a/.ghs.yml"), []byte("upload: false\ndelete: true\n"), 0644)
+	invalidateAccessConf()
+	conf := s.readAccessConf("a/b")
+	if conf.Upload || !conf.Delete {
+		t.Fatalf("changed conf not seen: %+v", conf)
+	}
+	if conf := s.readAccessConf("x/y"); conf.Upload || conf.Delete {
+		t.Fatalf("conf of not existing directory: %+v", conf)
+	}
+
+	// a subdirectory never changes the conf of its parent, and a malformed file is ignored as a whole
+	ioutil.WriteFile(filepath.Join(root, "a/.ghs.yml"), []byte("read: false\n"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "a/b/.ghs.yml"), []byte("read: true\nupload: true\nusers: [\n"), 0644)
+	invalidateAccessConf()
+	if conf := s.readAccessConf("a/b"); *conf.Read || conf.Upload || len(conf.Warnings) == 0 {
+		t.Fatalf("malformed conf applied: %+v", conf)
+	}
+	ioutil.WriteFile(filepath.Join(root, "a/b/.ghs.yml"), []byte("read: true\n"), 0644)
+	invalidateAccessConf()
+	s.readAccessConf("a/b")
+	if conf := s.readAccessConf("a"); *conf.Read {
+		t.Fatalf("conf of parent changed: %+v", conf)
+	}
+	ioutil.WriteFile(filepath.Join(root, "a/.ghs.yml"), []byte("read: true\n"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "a/b/.ghs.yml"), []byte("read: false\n"), 0644)
+	invalidateAccessConf()
+	s.readAccessConf("a/b")
+	if conf := s.readAccessConf("a"); !*conf.Read {
+		t.Fatalf("conf of parent changed: %+v", conf)
+	}
+
+	// confs returned are copies, changing them keeps the cached one
+	invalidateAccessConf()
+	conf = s.readAccessConf("a")
+	*conf.Read = false
+	conf.Warnings = append(conf.Warnings, "changed")
+	if conf := s.readAccessConf("a"); !*conf.Read || len(conf.Warnings) != 0 {
+		t.Fatalf("cached conf changed: %+v", conf)
+	}
+}
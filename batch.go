@@ -0,0 +1,340 @@
+package main
+
+// Batch operations, a list of delete, move, copy and mkdir is checked as a whole before any is run.
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	maxBatchOperations = 1000
+	maxBatchBodySize   = 1 << 20
+)
+
+type BatchOperation struct {
+	Op         string `json:"op"`             // delete, move, copy or mkdir
+	Path       string `json:"path,omitempty"` // for delete and mkdir
+	Src        string `json:"src,omitempty"`  // for move and copy
+	Dst        string `json:"dst,omitempty"`
+	OnConflict string `json:"onConflict,omitempty"`
+	Template   string `json:"template,omitempty"` // ACL template of mkdir, --mkdir-template if not set
+}
+
+type BatchRequest struct {
+	Operations  []BatchOperation `json:"operations"`
+	DryRun      bool             `json:"dryRun"`      // only check the operations
+	StopOnError bool             `json:"stopOnError"` // skip the rest after a failed operation
+	OnConflict  string           `json:"onConflict"`  // default of operations
+}
+
+type BatchResult struct {
+	Op     string `json:"op"`
+	Path   string `json:"path"`
+	Dst    string `json:"dst,omitempty"` // where it finally goes for move and copy
+	Status string `json:"status"`        // ok, failed or skipped
+	Error  string `json:"error,omitempty"`
+	code   int
+}
+
+// batchPlan tracks paths created and removed by earlier operations of the batch,
+// so later ones are checked against the state they will run in
+type batchPlan struct {
+	root    string
+	created map[string]bool // path to isDir
+	removed map[string]bool
+}
+
+func (p *batchPlan) lookup(path string) (exists, dir bool) {
+	if isDir, ok := p.created[path]; ok {
+		return true, isDir
+	}
+	for q := path; q != "."; q = filepath.Dir(q) {
+		if p.removed[q] {
+			return false, false
+		}
+	}
+	info, err := os.Lstat(filepath.Join(p.root, path))
+	if err != nil {
+		return false, false
+	}
+	return true, info.IsDir()
+}
+
+// checkBatchOperation validates op against the planned state and updates it, the cleaned op is returned
+func (s *HTTPStaticServer) checkBatchOperation(req *http.Request, p *batchPlan, op BatchOperation) (BatchOperation, error) {
+	var err error
+	switch op.Op {
+	case "delete", "mkdir":
+		if op.Path, err = cleanRequestPath(op.Path); err != nil {
+			return op, err
+		}
+		if !s.accessible(op.Path) {
+			return op, newStatusError(http.StatusNotFound, "Not exists: %s", op.Path)
+		}
+		exists, isDir := p.lookup(op.Path)
+		if op.Op == "delete" {
+			if !exists {
+				return op, newStatusError(http.StatusNotFound, "Not exists: %s", op.Path)
+			}
+			if auth := s.readAccessConf(op.Path); !auth.canDelete(req) {
+				return op, newStatusError(http.StatusForbidden, "Delete forbidden: %s", op.Path)
+			}
+			if err = s.checkAccessConfWrite(req, op.Path); err != nil {
+				return op, err
+			}
+			p.removed[op.Path] = true
+			delete(p.created, op.Path)
+			return op, nil
+		}
+		if exists {
+			return op, newStatusError(http.StatusConflict, "Already exists: %s", op.Path)
+		}
+		parent := filepath.Dir(op.Path)
+		if _, isDir = p.lookup(parent); !isDir {
+			return op, newStatusError(http.StatusConflict, "Directory not exists: %s", filepath.ToSlash(parent))
+		}
+		if auth := s.readAccessConf(parent); !auth.canMKDir(req) {
+			return op, newStatusError(http.StatusForbidden, "Mkdir forbidden: %s", filepath.ToSlash(parent))
+		}
+		if _, err = s.mkdirTemplate(op.Template); err != nil {
+			return op, err
+		}
+		p.created[op.Path] = true
+		return op, nil
+	case "move", "copy":
+		if op.Src, err = cleanRequestPath(op.Src); err != nil {
+			return op, err
+		}
+		if op.Dst, err = cleanRequestPath(op.Dst); err != nil {
+			return op, err
+		}
+		exists, isDir := p.lookup(op.Src)
+		if !exists {
+			return op, newStatusError(http.StatusNotFound, "Source not exists: %s", op.Src)
+		}
+		dstDir := filepath.Dir(op.Dst)
+		if _, dir := p.lookup(dstDir); !dir {
+			return op, newStatusError(http.StatusConflict, "Destination directory not exists: %s", filepath.ToSlash(dstDir))
+		}
+		policy, err := s.checkMoveOrCopy(req, op.Src, op.Dst, op.Op == "move", op.OnConflict)
+		if err != nil {
+			return op, err
+		}
+		if taken, _ := p.lookup(op.Dst); taken {
+			switch policy {
+			case conflictReject:
+				return op, newStatusError(http.StatusConflict, "Destination already exists: %s", op.Dst)
+			case conflictOverwrite:
+				p.removed[op.Dst] = true
+			default:
+				// renamed on conflict, the final name is only known when it runs
+				return op, nil
+			}
+		}
+		if op.Op == "move" {
+			p.removed[op.Src] = true
+			delete(p.created, op.Src)
+		}
+		p.created[op.Dst] = isDir
+		return op, nil
+	}
+	return op, newStatusError(http.StatusBadRequest, "Invalid op: %q", op.Op)
+}
+
+// runBatchOperation runs op checked by checkBatchOperation, and returns where move or copy finally goes
+func (s *HTTPStaticServer) runBatchOperation(req *http.Request, op BatchOperation) (string, error) {
+	switch op.Op {
+	case "delete":
+		user, ip := requestAuthor(req)
+		_, err := s.deletePath(op.Path, user, ip, nil)
+		return "", err
+	case "mkdir":
+		folder := filepath.Join(s.Root, op.Path)
+		if _, err := os.Lstat(folder); err == nil {
+			return "", newStatusError(http.StatusConflict, "Already exists: %s", op.Path)
+		}
+		templateData, err := s.mkdirTemplate(op.Template)
+		if err == nil {
+			err = makeDir(folder, templateData)
+		}
+		return "", err
+	}
+	return s.moveOrCopy(req, op.Src, op.Dst, op.Op == "move", op.OnConflict)
+}
+
+func (r *BatchResult) fail(err error) {
+	r.Status = "failed"
+	r.Error = err.Error()
+	r.code = http.StatusInternalServerError
+	switch e := err.(type) {
+	case *statusError:
+		r.code = e.Code
+	case *preconditionError:
+		r.code = http.StatusPreconditionFailed
+	}
+}
+
+// hBatch runs operations in order. All of them are checked first, nothing is run if any check fails,
+// and with "dryRun" nothing is run at all. Each operation has a result with status ok, failed or skipped.
+func (s *HTTPStaticServer) hBatch(w http.ResponseWriter, req *http.Request) {
+	var batch BatchRequest
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxBatchBodySize)).Decode(&batch); err != nil {
+		http.Error(w, "Invalid batch request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(batch.Operations) == 0 {
+		http.Error(w, "No operations", http.StatusBadRequest)
+		return
+	}
+	if len(batch.Operations) > maxBatchOperations {
+		http.Error(w, "Too many operations", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	results := make([]BatchResult, len(batch.Operations))
+	ops := make([]BatchOperation, len(batch.Operations))
+	plan := &batchPlan{root: s.Root, created: map[string]bool{}, removed: map[string]bool{}}
+	failed := -1
+	for i, op := range batch.Operations {
+		if op.OnConflict == "" {
+			op.OnConflict = batch.OnConflict
+		}
+		op, err := s.checkBatchOperation(req, plan, op)
+		ops[i] = op
+		results[i] = BatchResult{Op: op.Op, Path: op.Path, Status: "ok"}
+		if op.Op == "move" || op.Op == "copy" {
+			results[i].Path, results[i].Dst = op.Src, op.Dst
+		}
+		if err != nil {
+			results[i].fail(err)
+			if failed < 0 {
+				failed = i
+			}
+		}
+	}
+	if failed < 0 && !batch.DryRun {
+		for i, op := range ops {
+			if failed >= 0 && batch.StopOnError {
+				results[i].Status = "skipped"
+				continue
+			}
+			dst, err := s.runBatchOperation(req, op)
+			if err != nil {
+				log.Printf("Batch %s %s: %v", op.Op, results[i].Path, err)
+				results[i].fail(err)
+				if failed < 0 {
+					failed = i
+				}
+				continue
+			}
+			if dst != "" {
+				results[i].Dst = dst
+			}
+		}
+	} else if failed >= 0 && !batch.DryRun {
+		for i := range results {
+			if results[i].Status == "ok" {
+				results[i].Status = "skipped"
+			}
+		}
+	}
+
+	res := map[string]interface{}{
+		"success": failed < 0,
+		"dryRun":  batch.DryRun,
+		"results": results,
+	}
+	code := http.StatusOK
+	if failed >= 0 {
+		res["error"] = results[failed].Error
+		code = results[failed].code
+	}
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(res)
+}
+
+// hBatchZip downloads files and directories "path" (repeated, or a JSON list "paths") as one zip,
+// entries are named by their paths from root
+func (s *HTTPStaticServer) hBatchZip(w http.ResponseWriter, req *http.Request) {
+	var paths []string
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		var body struct {
+			Paths []string `json:"paths"`
+		}
+		if err := json.NewDecoder(io.LimitReader(req.Body, maxBatchBodySize)).Decode(&body); err != nil {
+			http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		paths = body.Paths
+	} else {
+		req.ParseForm()
+		paths = req.Form["path"]
+	}
+	if len(paths) == 0 {
+		http.Error(w, "No paths", http.StatusBadRequest)
+		return
+	}
+	if len(paths) > maxBatchOperations {
+		http.Error(w, "Too many paths", http.StatusRequestEntityTooLarge)
+		return
+	}
+	// everything is checked before the response starts
+	for i, name := range paths {
+		path, err := cleanRequestPath(name)
+		if err == nil {
+			if _, statErr := os.Lstat(filepath.Join(s.Root, path)); statErr != nil {
+				err = newStatusError(http.StatusNotFound, "Not exists: %s", path)
+			}
+		}
+		if err == nil && !s.checkRead(w, req, path) {
+			return
+		}
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		paths[i] = path
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="download.zip"`)
+	zw := &Zip{Writer: zip.NewWriter(w)}
+	defer zw.Close()
+	added := make(map[string]bool)
+	filter := s.zipFilter(req)
+	for _, path := range paths {
+		err := filepath.Walk(filepath.Join(s.Root, path), func(localPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				return nil
+			}
+			if !filter(localPath, info) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			rel, _ := filepath.Rel(s.Root, localPath)
+			// the same file given twice, or inside a directory given too
+			if added[rel] {
+				return nil
+			}
+			added[rel] = true
+			return zw.Add(rel, localPath)
+		})
+		if err != nil {
+			log.Println("Batch zip:", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-yaml/yaml"
+)
+
+func TestBatchDeleteAndMkdir(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "sub", ".ghs.yml"), []byte("delete: true\n"), 0644)
+	s := NewHTTPStaticServer(root)
+	s.MKDir = true
+	s.ACLTemplates = map[string]yaml.MapSlice{"private": {{Key: "loginRequired", Value: true}}}
+
+	tests := []struct {
+		body    string
+		code    int
+		exists  []string
+		missing []string
+	}{
+		// delete is decided by the conf of the path itself, like DELETE
+		{`{"operations": [{"op": "delete", "path": "sub"}]}`, http.StatusOK, nil, []string{"sub"}},
+		{`{"operations": [{"op": "mkdir", "path": "a", "template": "unknown"}]}`, http.StatusBadRequest, nil, []string{"a"}},
+		{`{"operations": [{"op": "mkdir", "path": "a", "template": "private"}]}`, http.StatusOK, []string{"a/.ghs.yml"}, nil},
+	}
+	for _, v := range tests {
+		invalidateAccessConf()
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("POST", "/-/batch", strings.NewReader(v.body)))
+		if w.Code != v.code {
+			t.Fatalf("Failed: %s - code:%d %s", v.body, w.Code, w.Body.String())
+		}
+		for _, name := range v.exists {
+			if !isFile(filepath.Join(root, name)) {
+				t.Fatalf("Failed: %s - %s not exists", v.body, name)
+			}
+		}
+		for _, name := range v.missing {
+			if _, err := os.Lstat(filepath.Join(root, name)); err == nil {
+				t.Fatalf("Failed: %s - %s should not exist", v.body, name)
+			}
+		}
+	}
+	if auth := s.readAccessConf("a"); !auth.LoginRequired {
+		t.Fatal("Failed: template of mkdir not applied")
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/go-yaml/yaml"
+	"github.com/gorilla/mux"
+)
+
+// channelHistoryFile keeps every promotion of a checkout directory, entries are only appended
+const channelHistoryFile = ".ghs.channel.yml"
+
+type ChannelEntry struct {
+	Action   string `yaml:"action" json:"action"` // promote, rollback or rollout-<action>
+	File     string `yaml:"file" json:"file"`
+	Previous string `yaml:"previous" json:"previous"`
+	To       *int   `yaml:"to,omitempty" json:"to,omitempty"`           // history index restored by rollback, not set undoes the last promotion
+	Percent  int    `yaml:"percent,omitempty" json:"percent,omitempty"` // rollout percentage
+	User     string `yaml:"user,omitempty" json:"user,omitempty"`
+	IP       string `yaml:"ip" json:"ip"`
+	Time     int64  `yaml:"time" json:"time"` // milliseconds
+}
+
+func readChannelHistory(dir string) (entries []ChannelEntry, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, channelHistoryFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	err = yaml.Unmarshal(data, &entries)
+	return
+}
+
+// channelReleases replays promotions and rollbacks of history, rollout entries are skipped, and returns the checked files which
+// rollback walks back through, the current one last. A promotion or rollback to an index adds a file,
+// a plain rollback removes the current one.
+func channelReleases(history []ChannelEntry) []string {
+	var releases []string
+	for _, entry := range history {
+		switch entry.Action {
+		case "promote":
+		case "rollback":
+			if entry.To == nil {
+				if len(releases) > 0 {
+					releases = releases[:len(releases)-1]
+				}
+				continue
+			}
+		default:
+			continue
+		}
+		if len(releases) == 0 {
+			releases = append(releases, entry.Previous) // checked before the first promotion
+		}
+		releases = append(releases, entry.File)
+	}
+	return releases
+}
+
+func appendChannelHistory(dir string, entry ChannelEntry) error {
+	data, err := yaml.Marshal([]ChannelEntry{entry})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, channelHistoryFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// setChannelFile changes the checked file of directory and records it in the channel history.
+// It should be called with fileWriteMu held.
+func (s *HTTPStaticServer) setChannelFile(req *http.Request, path, action, fileName string, to *int) (entry ChannelEntry, err error) {
+	relPath := filepath.Join(s.Root, path)
+	entry = ChannelEntry{
+		Action:   action,
+		File:     fileName,
+		Previous: s.readAccessConf(path).Checked,
+		To:       to,
+		IP:       getRealIP(req),
+		Time:     time.Now().UnixNano() / 1e6,
+	}
+	if user := currentUser(req); user != nil {
+		entry.User = user.Email
+	}
+	var checked interface{} // nil removes the key, and checkout falls back to the latest file
+	if fileName != "" {
+		checked = fileName
+	}
+	if err = updateAccessConf(relPath, map[string]interface{}{"checked": checked}); err != nil {
+		return
+	}
+	err = appendChannelHistory(relPath, entry)
+	return
+}
+
+// validChannelFile reports whether name could be served as checkout file of directory path
+func (s *HTTPStaticServer) validChannelFile(path, name string) bool {
+	if name == "" || filepath.Base(name) != name || uncheckedFileRegx.MatchString(name) {
+		return false
+	}
+	return s.accessible(filepath.Join(path, name)) && isFile(filepath.Join(s.Root, path, name))
+}
+
+// promote a file in directory to be the checked one
+func (s *HTTPStaticServer) hCheckoutPromote(w http.ResponseWriter, req *http.Request) {
+	path := mux.Vars(req)["path"]
+	if !s.accessible(path) {
+		http.NotFound(w, req)
+		return
+	}
+	fileWriteMu.Lock()
+	defer fileWriteMu.Unlock()
+	relPath := filepath.Join(s.Root, path)
+	auth := s.readAccessConf(path)
+	if !auth.canUpload(req) {
+		http.Error(w, "Promote forbidden", http.StatusForbidden)
+		return
+	}
+	if !isDir(relPath) {
+		http.Error(w, "Promote forbidden: directory not exists "+path, http.StatusForbidden)
+		return
+	}
+	fileName := req.FormValue("file")
+	if !s.validChannelFile(path, fileName) {
+		http.Error(w, "Promote failed: not valid file "+strconv.Quote(fileName), http.StatusBadRequest)
+		return
+	}
+	entry, err := s.setChannelFile(req, path, "promote", fileName, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"entry":   entry,
+	})
+}
+
+// rollback restores the file checked before the current one was promoted, or the history entry given by "to".
+// Rolling back again goes on to earlier promotions.
+func (s *HTTPStaticServer) hCheckoutRollback(w http.ResponseWriter, req *http.Request) {
+	path := mux.Vars(req)["path"]
+	if !s.accessible(path) {
+		http.NotFound(w, req)
+		return
+	}
+	fileWriteMu.Lock()
+	defer fileWriteMu.Unlock()
+	relPath := filepath.Join(s.Root, path)
+	auth := s.readAccessConf(path)
+	if !auth.canUpload(req) {
+		http.Error(w, "Rollback forbidden", http.StatusForbidden)
+		return
+	}
+	history, err := readChannelHistory(relPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(history) == 0 {
+		http.Error(w, "Rollback failed: no channel history in "+path, http.StatusConflict)
+		return
+	}
+	var fileName string
+	var toIdx *int
+	if to := req.FormValue("to"); to != "" {
+		idx, err := strconv.Atoi(to)
+		if err != nil || idx < 0 || idx >= len(history) {
+			http.Error(w, "Rollback failed: invalid history index "+strconv.Quote(to), http.StatusBadRequest)
+			return
+		}
+		// rollout entries only record the candidate, which was never the checked file
+		if action := history[idx].Action; action != "promote" && action != "rollback" {
+			http.Error(w, "Rollback failed: history entry "+to+" is "+action+", not a promotion", http.StatusBadRequest)
+			return
+		}
+		fileName, toIdx = history[idx].File, &idx
+	} else {
+		releases := channelReleases(history)
+		if len(releases) < 2 {
+			http.Error(w, "Rollback failed: no earlier promotion in "+path, http.StatusConflict)
+			return
+		}
+		fileName = releases[len(releases)-2]
+	}
+	if fileName != "" && !s.validChannelFile(path, fileName) {
+		http.Error(w, "Rollback failed: file no longer exists "+strconv.Quote(fileName), http.StatusConflict)
+		return
+	}
+	entry, err := s.setChannelFile(req, path, "rollback", fileName, toIdx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"entry":   entry,
+	})
+}
+
+func (s *HTTPStaticServer) hChannelHistory(w http.ResponseWriter, req *http.Request) {
+	path := mux.Vars(req)["path"]
+	relPath := filepath.Join(s.Root, path)
+	auth := s.readAccessConf(path)
+	if !s.checkRead(w, req, path) {
+		return
+	}
+	if !isDir(relPath) {
+		http.Error(w, "Channel forbidden: directory not exists "+path, http.StatusForbidden)
+		return
+	}
+	history, err := readChannelHistory(relPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if history == nil {
+		history = make([]ChannelEntry, 0)
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"checked": auth.Checked,
+		"rollout": map[string]interface{}{
+			"candidate": auth.Candidate,
+			"percent":   auth.Rollout,
+			"paused":    auth.RolloutPaused,
+		},
+		"history": history,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
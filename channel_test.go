@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChannelReleases(t *testing.T) {
+	to := func(idx int) *int { return &idx }
+	tests := []struct {
+		history  []ChannelEntry
+		releases []string
+	}{
+		{nil, nil},
+		{[]ChannelEntry{{Action: "promote", File: "a"}}, []string{"", "a"}},
+		{[]ChannelEntry{{Action: "promote", File: "b", Previous: "a"}}, []string{"a", "b"}},
+		{[]ChannelEntry{
+			{Action: "promote", File: "a"},
+			{Action: "promote", File: "b", Previous: "a"},
+			{Action: "promote", File: "c", Previous: "b"},
+			{Action: "rollback", File: "b", Previous: "c"},
+		}, []string{"", "a", "b"}},
+		{[]ChannelEntry{
+			{Action: "promote", File: "a"},
+			{Action: "promote", File: "b", Previous: "a"},
+			{Action: "rollback", File: "a", Previous: "b"},
+			{Action: "rollback", File: "", Previous: "a"},
+		}, []string{""}},
+		{[]ChannelEntry{
+			{Action: "promote", File: "a"},
+			{Action: "promote", File: "b", Previous: "a"},
+			{Action: "rollback", File: "a", Previous: "b", To: to(0)},
+		}, []string{"", "a", "b", "a"}},
+		{[]ChannelEntry{
+			{Action: "promote", File: "a"},
+			{Action: "rollout-start", File: "b", Previous: "a", Percent: 10},
+			{Action: "rollout-abort", File: "b", Previous: "a", Percent: 10},
+			{Action: "rollout-start", File: "c", Previous: "a", Percent: 10},
+			{Action: "promote", File: "c", Previous: "a"},
+		}, []string{"", "a", "c"}},
+	}
+	for _, v := range tests {
+		if res := channelReleases(v.history); !reflect.DeepEqual(res, v.releases) {
+			t.Fatalf("Failed: %v - res:%v", v, res)
+		}
+	}
+}
+
+func TestChannelPromoteRollback(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "ch"), 0755)
+	for _, name := range []string{"a.apk", "b.apk", "c.apk"} {
+		ioutil.WriteFile(filepath.Join(root, "ch", name), []byte(name), 0644)
+	}
+	s := NewHTTPStaticServer(root)
+	s.Upload = true
+	post := func(url, body string) int {
+		req := httptest.NewRequest("POST", url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		url     string
+		body    string
+		code    int
+		checked string
+	}{
+		{"/-/rollback/ch", "", http.StatusConflict, ""},
+		{"/-/checkout/ch", "file=a.apk", http.StatusOK, "a.apk"},
+		{"/-/checkout/ch", "file=b.apk", http.StatusOK, "b.apk"},
+		{"/-/checkout/ch", "file=c.apk", http.StatusOK, "c.apk"},
+		{"/-/rollback/ch", "", http.StatusOK, "b.apk"},
+		{"/-/rollback/ch", "", http.StatusOK, "a.apk"},
+		{"/-/rollback/ch", "", http.StatusOK, ""},
+		{"/-/rollback/ch", "", http.StatusConflict, ""},
+		{"/-/rollback/ch", "to=2", http.StatusOK, "c.apk"},
+		{"/-/rollback/ch", "", http.StatusOK, ""},
+		{"/-/rollback/ch", "to=9", http.StatusBadRequest, ""},
+		{"/-/checkout/ch", "file=a.apk", http.StatusOK, "a.apk"},
+		{"/-/rollout/ch", "action=start&file=b.apk&percent=10", http.StatusOK, "a.apk"},
+		{"/-/rollout/ch", "action=abort", http.StatusOK, "a.apk"},
+		{"/-/rollback/ch", "to=11", http.StatusBadRequest, "a.apk"},
+		{"/-/rollback/ch", "", http.StatusOK, ""},
+	}
+	for i, v := range tests {
+		if code := post(v.url, v.body); code != v.code {
+			t.Fatalf("Failed: %d %v - code:%d", i, v, code)
+		}
+		invalidateAccessConf()
+		if checked := s.readAccessConf("ch").Checked; checked != v.checked {
+			t.Fatalf("Failed: %d %v - checked:%q", i, v, checked)
+		}
+	}
+	history, err := readChannelHistory(filepath.Join(root, "ch"))
+	if err != nil || len(history) != 12 {
+		t.Fatalf("history: %v %v", history, err)
+	}
+	if last := history[len(history)-1]; last.Action != "rollback" || last.Previous != "a.apk" || last.To != nil {
+		t.Fatalf("last entry: %+v", last)
+	}
+}
+
+func TestUpdateAccessConf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		data   string
+		values map[string]interface{}
+		expect string
+	}{
+		{"", map[string]interface{}{"checked": "a.apk"}, "---\nchecked: a.apk\n"},
+		// comments and other keys are kept as written
+		{"# release channel\nupload: true # ci only\nchecked: a.apk\n", map[string]interface{}{"checked": "b.apk", "rollout": 10},
+			"---\n# release channel\nupload: true # ci only\nchecked: b.apk\nrollout: 10\n"},
+		{"users:\n- email: a@x.com\n  upload: true\ncandidate: b.apk\nrollout: 10\n", map[string]interface{}{"candidate": nil, "rollout": nil},
+			"---\nusers:\n- email: a@x.com\n  upload: true\n"},
+		{"checked: |\n  a.apk\nupload: true\n", map[string]interface{}{"checked": "b.apk"}, "---\nchecked: b.apk\nupload: true\n"},
+		// not laid out in lines, marshalled again
+		{`{"upload": true, "checked": "a.apk"}`, map[string]interface{}{"checked": "b.apk"}, "---\nupload: true\nchecked: b.apk\n"},
+	}
+	for _, v := range tests {
+		ioutil.WriteFile(filepath.Join(dir, ".ghs.yml"), []byte(v.data), 0644)
+		if err := updateAccessConf(dir, v.values); err != nil {
+			t.Fatalf("Failed: %q - err:%v", v.data, err)
+		}
+		if data, _ := ioutil.ReadFile(filepath.Join(dir, ".ghs.yml")); string(data) != v.expect {
+			t.Fatalf("Failed: %q - got:%q", v.data, data)
+		}
+	}
+}
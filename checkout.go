@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkout modes, could be set by "checkout" in ".ghs.yml" or the "mode" query
+const (
+	checkoutLatest = "latest" // the latest modified file, the default one
+	checkoutSemver = "semver" // the highest version parsed from file names
+)
+
+// checkoutFiles lists files in directory which could be checked out
+func checkoutFiles(relPath string) ([]os.FileInfo, error) {
+	fileInfos, err := ioutil.ReadDir(relPath)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]os.FileInfo, 0, len(fileInfos))
+	for _, fileInfo := range fileInfos {
+		// ignore directory, ".yml" or ".md" file and files being uploaded
+		if fileInfo.IsDir() || uncheckedFileRegx.MatchString(fileInfo.Name()) ||
+			strings.HasPrefix(fileInfo.Name(), uploadTempPrefix) {
+			continue
+		}
+		files = append(files, fileInfo)
+	}
+	return files, nil
+}
+
+// setCheckoutVary tells caches which request headers the checkout result depends on
+func setCheckoutVary(w http.ResponseWriter, auth AccessConf) {
+	if auth.Platform != "" {
+		w.Header().Add("Vary", "User-Agent")
+	}
+	if auth.Candidate != "" {
+		w.Header().Add("Vary", clientIDHeader)
+	}
+}
+
+// resolveCheckout returns name of the file which should be served when checking out directory path.
+// The "checked" file always wins, unless the request asks for a version or a platform it does not satisfy.
+func (s *HTTPStaticServer) resolveCheckout(req *http.Request, path string, auth AccessConf) (name string, version *Version, err error) {
+	relPath := filepath.Join(s.Root, path)
+	mode := auth.Checkout
+	if m := req.FormValue("mode"); m != "" {
+		mode = m
+	}
+	var constraint *VersionConstraint
+	if expr := req.FormValue("version"); expr != "" {
+		c, err := parseVersionConstraint(expr)
+		if err != nil {
+			return "", nil, newStatusError(http.StatusBadRequest, "Checkout failed: %v", err)
+		}
+		constraint = c
+		mode = checkoutSemver
+	}
+	switch mode {
+	case "", checkoutLatest, checkoutSemver:
+	default:
+		return "", nil, newStatusError(http.StatusBadRequest, "Checkout failed: unknown mode %s", strconv.Quote(mode))
+	}
+
+	// with "platform" pattern, only files built for the requested platform are taken into account
+	// files hidden by accessTables are never served
+	match := func(name string) bool { return auth.canAccess(filepath.Join(path, name)) }
+	versionOf := versionFromFileName
+	if auth.Platform != "" {
+		p, _ := requestPlatform(req)
+		// clients like curl tell no platform, they get "defaultPlatform", or any platform with "checked" first
+		if p.OS == "" && p.Arch == "" {
+			p = parsePlatform(auth.DefaultPlatform)
+		}
+		re, err := platformPattern(auth.Platform, p)
+		if err != nil {
+			return "", nil, newStatusError(http.StatusInternalServerError, "Checkout failed: invalid platform pattern: %v", err)
+		}
+		match = func(name string) bool { return re.MatchString(name) && auth.canAccess(filepath.Join(path, name)) }
+		versionOf = func(name string) *Version { return platformVersion(re, name) }
+	}
+
+	// a staged rollout serves the candidate to a stable fraction of clients
+	if candidate := auth.Candidate; candidate != "" && !auth.RolloutPaused && match(candidate) &&
+		isFile(filepath.Join(relPath, candidate)) && rolloutBucket(req, path, candidate) < auth.Rollout {
+		v := versionOf(candidate)
+		if constraint == nil || (v != nil && constraint.Check(v)) {
+			return candidate, v, nil
+		}
+	}
+
+	if checked := auth.Checked; checked != "" && match(checked) {
+		checkedFilePath := filepath.Join(relPath, checked)
+		if !isFile(checkedFilePath) {
+			return "", nil, newStatusError(http.StatusForbidden, "Checkout forbidden: not valid file %s", checkedFilePath)
+		}
+		v := versionOf(checked)
+		if constraint == nil || (v != nil && constraint.Check(v)) {
+			return checked, v, nil
+		}
+	}
+
+	files, err := checkoutFiles(relPath)
+	if err != nil {
+		return "", nil, newStatusError(http.StatusForbidden, "Checkout forbidden: no content in %s", path)
+	}
+	var latest os.FileInfo
+	var latestVersion *Version
+	for _, fileInfo := range files {
+		if !match(fileInfo.Name()) {
+			continue
+		}
+		if mode != checkoutSemver {
+			if latest == nil || fileInfo.ModTime().After(latest.ModTime()) {
+				latest = fileInfo
+			}
+			continue
+		}
+		v := versionOf(fileInfo.Name())
+		if v == nil || (constraint != nil && !constraint.Check(v)) {
+			continue
+		}
+		c := 1
+		if latest != nil {
+			c = v.Compare(latestVersion)
+		}
+		// same version in different files, prefer the latest modified one
+		if c > 0 || (c == 0 && fileInfo.ModTime().After(latest.ModTime())) {
+			latest, latestVersion = fileInfo, v
+		}
+	}
+	if latest == nil {
+		if constraint != nil {
+			return "", nil, newStatusError(http.StatusNotFound, "Checkout failed: no version matches %s in %s", req.FormValue("version"), path)
+		}
+		if auth.Platform != "" {
+			p, _ := requestPlatform(req)
+			return "", nil, newStatusError(http.StatusNotFound, "Checkout failed: no file for platform %s/%s in %s", p.OS, p.Arch, path)
+		}
+		return "", nil, newStatusError(http.StatusForbidden, "Checkout forbidden: no content in %s", path)
+	}
+	if latestVersion == nil {
+		latestVersion = versionOf(latest.Name())
+	}
+	return latest.Name(), latestVersion, nil
+}
+
+// maxCheckoutWait limits how long a long poll checkout request could be held
+const maxCheckoutWait = 5 * time.Minute
+
+// parseWaitDuration parses durations like "60s" or "60" (seconds)
+func parseWaitDuration(wait string) (time.Duration, error) {
+	if _, err := strconv.Atoi(wait); err == nil {
+		wait += "s"
+	}
+	d, err := time.ParseDuration(wait)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %s", strconv.Quote(wait))
+	}
+	if d > maxCheckoutWait {
+		d = maxCheckoutWait
+	}
+	return d, nil
+}
+
+// checkoutETag changes when another file is resolved or the resolved file is modified
+func checkoutETag(name string, info os.FileInfo) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return fmt.Sprintf(`"%x-%x-%x"`, h.Sum32(), info.ModTime().UnixNano(), info.Size())
+}
+
+// waitCheckout resolves checkout file of directory path, until its etag differs from after or timeout expires.
+// The resolving is done only once if timeout is zero.
+func (s *HTTPStaticServer) waitCheckout(req *http.Request, path, after string, timeout time.Duration) (name, etag string, err error) {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		// .ghs.yml is read again every time, since promotion changes it
+		name, _, err = s.resolveCheckout(req, path, s.readAccessConf(path))
+		if err == nil {
+			var info os.FileInfo
+			if info, err = os.Stat(filepath.Join(s.Root, path, name)); err == nil {
+				etag = checkoutETag(name, info)
+				if etag != after {
+					return
+				}
+			}
+		}
+		if timeout <= 0 {
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			return
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseWaitDuration(t *testing.T) {
+	tests := []struct {
+		wait string
+		d    time.Duration
+		pass bool
+	}{
+		{"60", time.Minute, true},
+		{"1.5s", 1500 * time.Millisecond, true},
+		{"1h", maxCheckoutWait, true},
+		{"-1s", 0, false},
+		{"soon", 0, false},
+	}
+	for _, v := range tests {
+		d, err := parseWaitDuration(v.wait)
+		if (err == nil) != v.pass || d != v.d {
+			t.Fatalf("Failed: %v - res:%v %v", v, d, err)
+		}
+	}
+}
+
+func TestCheckoutWait(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "ch"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "ch", "a.apk"), []byte("a"), 0644)
+	s := NewHTTPStaticServer(root)
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	w := get("/-/checkout/ch")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("Failed: checkout - code:%d etag:%q", w.Code, etag)
+	}
+	if w = get("/-/checkout/ch?wait=soon"); w.Code != http.StatusBadRequest {
+		t.Fatalf("Failed: invalid wait - code:%d", w.Code)
+	}
+	// nothing changes until timeout
+	start := time.Now()
+	if w = get("/-/checkout/ch?wait=1s&after=" + etag); w.Code != http.StatusNotModified || w.Header().Get("ETag") != etag {
+		t.Fatalf("Failed: timeout - code:%d etag:%q", w.Code, w.Header().Get("ETag"))
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("Failed: returned after %v", elapsed)
+	}
+	// a stale etag is answered at once
+	if w = get("/-/checkout/ch?wait=60s&after=%22stale%22"); w.Code != http.StatusOK || w.Body.String() != "a" {
+		t.Fatalf("Failed: stale etag - code:%d %s", w.Code, w.Body.String())
+	}
+	// a newer file is served as soon as it appears
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		ioutil.WriteFile(filepath.Join(root, "ch", "b.apk"), []byte("b"), 0644)
+		later := time.Now().Add(time.Minute)
+		os.Chtimes(filepath.Join(root, "ch", "b.apk"), later, later)
+	}()
+	start = time.Now()
+	w = get("/-/checkout/ch?wait=60s&after=" + etag)
+	if w.Code != http.StatusOK || w.Body.String() != "b" || w.Header().Get("ETag") == etag {
+		t.Fatalf("Failed: new file - code:%d %s", w.Code, w.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("Failed: returned after %v", elapsed)
+	}
+}
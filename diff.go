@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// maxDiffCells limits the line comparisons of diffLines, larger changes are refused
+const maxDiffCells = 25000000
+
+// diffOp is a line of diff, kind is ' ', '-' or '+'. a and b are line indexes in both texts,
+// for inserted or deleted lines they are where the line would be.
+type diffOp struct {
+	kind byte
+	a, b int
+}
+
+// splitLines splits text after each newline, the last line has none if the text does not end with it
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// lcsLengths returns lengths of longest common subsequence of a and each b[:j], or of a and
+// each b[j:] if reverse. Only one row is kept so memory is linear.
+func lcsLengths(a, b []string, reverse bool) []int32 {
+	row := make([]int32, len(b)+1)
+	for i := range a {
+		if reverse {
+			i = len(a) - 1 - i
+		}
+		var diag int32
+		for k := range b {
+			j, cur := k, k+1
+			if reverse {
+				j, cur = len(b)-1-k, len(b)-1-k
+			}
+			up := row[cur]
+			switch {
+			case a[i] == b[j]:
+				row[cur] = diag + 1
+			case reverse && row[cur+1] > row[cur]:
+				row[cur] = row[cur+1]
+			case !reverse && row[cur-1] > row[cur]:
+				row[cur] = row[cur-1]
+			}
+			diag = up
+		}
+	}
+	return row
+}
+
+// diffRange appends ops of a and b starting at line offA and offB, splitting a in halves
+// at the middle of a longest common subsequence (Hirschberg)
+func diffRange(ops []diffOp, a, b []string, offA, offB int) []diffOp {
+	switch {
+	case len(a) == 0:
+		for j := range b {
+			ops = append(ops, diffOp{'+', offA, offB + j})
+		}
+		return ops
+	case len(b) == 0:
+		for i := range a {
+			ops = append(ops, diffOp{'-', offA + i, offB})
+		}
+		return ops
+	case len(a) == 1:
+		for j := range b {
+			if a[0] == b[j] {
+				ops = diffRange(ops, nil, b[:j], offA, offB)
+				ops = append(ops, diffOp{' ', offA, offB + j})
+				return diffRange(ops, nil, b[j+1:], offA+1, offB+j+1)
+			}
+		}
+		ops = append(ops, diffOp{'-', offA, offB})
+		return diffRange(ops, nil, b, offA+1, offB)
+	}
+	mid := len(a) / 2
+	head, tail := lcsLengths(a[:mid], b, false), lcsLengths(a[mid:], b, true)
+	split := 0
+	for j := range head {
+		if head[j]+tail[j] > head[split]+tail[split] {
+			split = j
+		}
+	}
+	ops = diffRange(ops, a[:mid], b[:split], offA, offB)
+	return diffRange(ops, a[mid:], b[split:], offA+mid, offB+split)
+}
+
+// diffLines compares lines by longest common subsequence, common prefix and suffix are skipped
+func diffLines(a, b []string) ([]diffOp, error) {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	am, bm := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	if n, m := len(am), len(bm); n*m > maxDiffCells {
+		return nil, fmt.Errorf("too many changed lines to diff: %d and %d", n, m)
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for i := 0; i < pre; i++ {
+		ops = append(ops, diffOp{' ', i, i})
+	}
+	ops = diffRange(ops, am, bm, pre, pre)
+	for k := 0; k < suf; k++ {
+		ops = append(ops, diffOp{' ', len(a) - suf + k, len(b) - suf + k})
+	}
+	return ops, nil
+}
+
+// hunkRange formats start and count of hunk header, like GNU diff an empty range starts at the line before
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// unifiedDiff returns diff of text a and b in unified format with context lines, empty if no difference
+func unifiedDiff(nameA, nameB, a, b string, context int) (string, error) {
+	linesA, linesB := splitLines(a), splitLines(b)
+	ops, err := diffLines(linesA, linesB)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	for k := 0; k < len(ops); {
+		if ops[k].kind == ' ' {
+			k++
+			continue
+		}
+		start := k - context
+		if start < 0 {
+			start = 0
+		}
+		// changes closer than 2*context lines share a hunk
+		end := k
+		for j := k + 1; j < len(ops) && j <= end+2*context; j++ {
+			if ops[j].kind != ' ' {
+				end = j
+			}
+		}
+		stop := end + context + 1
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		countA, countB := 0, 0
+		for _, op := range ops[start:stop] {
+			if op.kind != '+' {
+				countA++
+			}
+			if op.kind != '-' {
+				countB++
+			}
+		}
+		if buf.Len() == 0 {
+			fmt.Fprintf(buf, "--- %s\n+++ %s\n", nameA, nameB)
+		}
+		fmt.Fprintf(buf, "@@ -%s +%s @@\n", hunkRange(ops[start].a, countA), hunkRange(ops[start].b, countB))
+		for _, op := range ops[start:stop] {
+			line := ""
+			if op.kind == '+' {
+				line = linesB[op.b]
+			} else {
+				line = linesA[op.a]
+			}
+			buf.WriteByte(op.kind)
+			buf.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		k = stop
+	}
+	return buf.String(), nil
+}
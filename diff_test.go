@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		a, b string
+		diff string
+	}{
+		{"a\nb\nc\n", "a\nb\nc\n", ""},
+		{"a\nb\nc\n", "a\nx\nc\n", "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{"", "a\n", "--- a\n+++ b\n@@ -0,0 +1 @@\n+a\n"},
+		{"a\nb\n", "", "--- a\n+++ b\n@@ -1,2 +0,0 @@\n-a\n-b\n"},
+		{"1\n2\n3\n4\n5\n6\n7\n8\n9\n", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			"--- a\n+++ b\n@@ -7,3 +7,4 @@\n 7\n 8\n 9\n+10\n"},
+		{"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			"--- a\n+++ b\n@@ -1,3 +1,4 @@\n+0\n 1\n 2\n 3\n@@ -7,4 +8,3 @@\n 7\n 8\n 9\n-10\n"},
+		{"a\nb\nc\nd\n", "a\nc\nd\ne\n", "--- a\n+++ b\n@@ -1,4 +1,4 @@\n a\n-b\n c\n d\n+e\n"},
+		{"a\nb", "a\nb\n", "--- a\n+++ b\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n"},
+		{"a\n", "a\nb", "--- a\n+++ b\n@@ -1 +1,2 @@\n a\n+b\n\\ No newline at end of file\n"},
+		{"x\na\nb\ny\nc\n", "a\nz\nb\nc\ny\n",
+			"--- a\n+++ b\n@@ -1,5 +1,5 @@\n-x\n a\n+z\n b\n-y\n c\n+y\n"},
+	}
+	for _, v := range tests {
+		diff, err := unifiedDiff("a", "b", v.a, v.b, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff != v.diff {
+			t.Fatalf("Failed: %q %q\ngot:\n%s\nexpect:\n%s", v.a, v.b, diff, v.diff)
+		}
+	}
+}
+
+func TestDiffLinesLarge(t *testing.T) {
+	a, b := make([]string, 3000), make([]string, 3000)
+	for i := range a {
+		a[i] = fmt.Sprintf("%d\n", i)
+		b[i] = fmt.Sprintf("%d\n", (i*7)%3000)
+	}
+	ops, err := diffLines(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, j := 0, 0
+	for _, op := range ops {
+		switch op.kind {
+		case ' ':
+			if a[i] != b[j] {
+				t.Fatalf("Failed: %d %d are not equal", i, j)
+			}
+			i++
+			j++
+		case '-':
+			i++
+		case '+':
+			j++
+		}
+	}
+	if i != len(a) || j != len(b) {
+		t.Fatalf("Failed: ops cover %d and %d lines", i, j)
+	}
+	big, other := make([]string, 6000), make([]string, 6000)
+	for i := range big {
+		big[i], other[i] = fmt.Sprint(i), fmt.Sprint(-i-1)
+	}
+	if _, err = diffLines(big, other); err == nil {
+		t.Fatal("Failed: diff of 6000x6000 changed lines should be refused")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import "os"
+
+// fileInode returns 0, there is no inode number of file
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,287 @@
+package main
+
+// Upload and extract archives into a directory, .zip, .tar and .tar.gz are supported.
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	archiveZip   = "zip"
+	archiveTar   = "tar"
+	archiveTarGz = "tar.gz"
+)
+
+// archiveFormat detects format by "format" query, or by the archive file name
+func archiveFormat(req *http.Request, name string) string {
+	format := strings.ToLower(req.URL.Query().Get("format"))
+	if format == "" {
+		format = strings.ToLower(name)
+	}
+	switch {
+	case strings.HasSuffix(format, "tar.gz"), strings.HasSuffix(format, "tgz"):
+		return archiveTarGz
+	case strings.HasSuffix(format, "tar"):
+		return archiveTar
+	case strings.HasSuffix(format, "zip"):
+		return archiveZip
+	}
+	return ""
+}
+
+// extractLimit counts files and bytes extracted, archive bombs are stopped when limits are exceeded
+type extractLimit struct {
+	maxSize  int64
+	maxFiles int
+	size     int64
+	files    int
+}
+
+func (l *extractLimit) addFile() error {
+	l.files++
+	if l.maxFiles > 0 && l.files > l.maxFiles {
+		return newStatusError(http.StatusRequestEntityTooLarge, "Archive has more than %d files", l.maxFiles)
+	}
+	return nil
+}
+
+func (l *extractLimit) Reader(r io.Reader) io.Reader {
+	return &limitedReader{r, l}
+}
+
+// limitedReader fails when total bytes read exceeds the limit, sizes declared by archive are not trusted
+type limitedReader struct {
+	r     io.Reader
+	limit *extractLimit
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.limit.size += int64(n)
+	if lr.limit.maxSize > 0 && lr.limit.size > lr.limit.maxSize {
+		return n, newStatusError(http.StatusRequestEntityTooLarge, "Archive is larger than %d bytes when extracted", lr.limit.maxSize)
+	}
+	return n, err
+}
+
+type archiveExtractor struct {
+	s       *HTTPStaticServer
+	req     *http.Request
+	path    string
+	limit   extractLimit
+	results []UploadResult
+	skipped []string
+}
+
+// entry extracts a file or directory of archive, anything else is skipped
+func (e *archiveExtractor) entry(name string, mode os.FileMode, r io.Reader) error {
+	switch {
+	case mode.IsDir():
+		// "./" of archives made by "tar -C dir ." is the target directory itself
+		if sanitizedName(name) == "." {
+			return nil
+		}
+		rel, err := uploadRelativePath(name)
+		if err == nil && !e.s.accessible(filepath.Join(e.path, rel)) {
+			err = newStatusError(http.StatusNotFound, "Not found: %s", filepath.ToSlash(rel))
+		}
+		if err == nil {
+			_, err = e.s.prepareUploadDir(e.req, e.path, filepath.Join(e.path, rel))
+		}
+		if err != nil {
+			result := UploadResult{Name: name, Error: err.Error(), code: http.StatusInternalServerError}
+			if se, ok := err.(*statusError); ok {
+				result.Error, result.code = se.Message, se.Code
+			}
+			e.results = append(e.results, result)
+		}
+	case mode.IsRegular():
+		if err := e.limit.addFile(); err != nil {
+			return err
+		}
+		result := e.s.saveUploadPart(e.req, e.path, name, e.limit.Reader(r), nil, nil)
+		e.results = append(e.results, result)
+		if result.code == http.StatusRequestEntityTooLarge {
+			return newStatusError(result.code, "%s", result.Error)
+		}
+	default:
+		// symlinks could point outside of root, they are never extracted
+		e.skipped = append(e.skipped, name)
+	}
+	return nil
+}
+
+func (e *archiveExtractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return newStatusError(http.StatusBadRequest, "Invalid tar archive: %v", err)
+		}
+		var mode os.FileMode
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			mode = os.ModeDir
+		case tar.TypeReg:
+			mode = 0
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			// hard links, symlinks and devices
+			mode = os.ModeIrregular
+		}
+		if err = e.entry(hdr.Name, mode, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func (e *archiveExtractor) extractZip(path string) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return newStatusError(http.StatusBadRequest, "Invalid zip archive: %v", err)
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		var rc io.ReadCloser
+		if f.Mode().IsRegular() {
+			if rc, err = f.Open(); err != nil {
+				return newStatusError(http.StatusBadRequest, "Invalid zip archive: %v", err)
+			}
+		}
+		err = e.entry(f.Name, f.Mode(), rc)
+		if rc != nil {
+			rc.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractSource returns the archive of request, the first file of a multipart form or the raw body
+func extractSource(req *http.Request) (name string, r io.Reader, err error) {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return req.URL.Query().Get("name"), req.Body, nil
+	}
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return "", nil, newStatusError(http.StatusBadRequest, "Parse multipart form: %v", err)
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return "", nil, newStatusError(http.StatusBadRequest, "Extract failed: no file found in request")
+		}
+		if err != nil {
+			return "", nil, newStatusError(http.StatusBadRequest, "Parse multipart form: %v", err)
+		}
+		if name = partFileName(part); name != "" {
+			return name, part, nil
+		}
+		part.Close()
+	}
+}
+
+// hExtract unpacks uploaded archive into directory path, files are stored like uploads
+func (s *HTTPStaticServer) hExtract(w http.ResponseWriter, req *http.Request) {
+	path := mux.Vars(req)["path"]
+	if !s.accessible(path) {
+		http.NotFound(w, req)
+		return
+	}
+	auth := s.readAccessConf(path)
+	if !auth.canUpload(req) {
+		http.Error(w, "Extract forbidden", http.StatusForbidden)
+		return
+	}
+	if !isDir(filepath.Join(s.Root, path)) {
+		http.Error(w, "Extract forbidden: directory not exists "+path, http.StatusForbidden)
+		return
+	}
+	name, r, err := extractSource(req)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	format := archiveFormat(req, name)
+	if format == "" {
+		http.Error(w, "Extract failed: unsupported archive "+name+", use .zip, .tar or .tar.gz", http.StatusBadRequest)
+		return
+	}
+
+	e := &archiveExtractor{
+		s:       s,
+		req:     req,
+		path:    path,
+		limit:   extractLimit{maxSize: s.ExtractMaxSize, maxFiles: s.ExtractMaxFiles},
+		skipped: make([]string, 0),
+	}
+	switch format {
+	case archiveZip:
+		// zip needs random access, it is saved to a temp file first
+		err = s.spoolArchive(r, e.extractZip)
+	case archiveTarGz:
+		var gr *gzip.Reader
+		if gr, err = gzip.NewReader(r); err != nil {
+			err = newStatusError(http.StatusBadRequest, "Invalid gzip archive: %v", err)
+			break
+		}
+		err = e.extractTar(gr)
+	default:
+		err = e.extractTar(r)
+	}
+
+	ret, code := uploadResponse(e.results)
+	ret["skipped"] = e.skipped
+	if err != nil {
+		code = http.StatusInternalServerError
+		if se, ok := err.(*statusError); ok {
+			code = se.Code
+		}
+		ret["success"] = false
+		ret["error"] = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(ret)
+}
+
+// spoolArchive saves r to a temp file under the internal directory, and calls fn with its path
+func (s *HTTPStaticServer) spoolArchive(r io.Reader, fn func(path string) error) error {
+	tmpDir := filepath.Join(s.Root, internalDir, "tmp")
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(tmpDir, "extract-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	limit := &extractLimit{maxSize: s.ExtractMaxSize}
+	_, err = io.Copy(f, limit.Reader(r))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return fn(f.Name())
+}
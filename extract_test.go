@@ -0,0 +1,148 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type archiveEntry struct {
+	name string
+	body string // "dir" for directories, "->target" for symlinks
+}
+
+func makeTar(entries []archiveEntry) []byte {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		switch {
+		case e.body == "dir":
+			hdr.Typeflag, hdr.Size, hdr.Mode = tar.TypeDir, 0, 0755
+		case strings.HasPrefix(e.body, "->"):
+			hdr.Typeflag, hdr.Size, hdr.Linkname = tar.TypeSymlink, 0, e.body[2:]
+		}
+		tw.WriteHeader(hdr)
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(e.body))
+		}
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+func makeZip(entries []archiveEntry) []byte {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, e := range entries {
+		w, _ := zw.Create(e.name)
+		w.Write([]byte(e.body))
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func gzipped(data []byte) []byte {
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	gw.Write(data)
+	gw.Close()
+	return buf.Bytes()
+}
+
+func TestArchiveFormat(t *testing.T) {
+	tests := []struct {
+		query  string
+		name   string
+		format string
+	}{
+		{"", "a.zip", archiveZip},
+		{"", "a.TAR", archiveTar},
+		{"", "a.tar.gz", archiveTarGz},
+		{"", "a.tgz", archiveTarGz},
+		{"format=tgz", "upload", archiveTarGz},
+		{"format=zip", "a.tar", archiveZip},
+		{"", "a.rar", ""},
+	}
+	for _, v := range tests {
+		req := httptest.NewRequest("POST", "/-/extract/?"+v.query, nil)
+		if res := archiveFormat(req, v.name); res != v.format {
+			t.Fatalf("Failed: %v - res:%v", v, res)
+		}
+	}
+}
+
+func TestExtractArchive(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	s := NewHTTPStaticServer(filepath.Join(root, "www"))
+	s.Upload, s.MKDir = true, true
+
+	tests := []struct {
+		query    string
+		body     []byte
+		maxSize  int64
+		maxFiles int
+		code     int
+		exists   []string // from root of the server
+		missing  []string // from the temp dir
+		skipped  int
+	}{
+		{"format=tar", makeTar([]archiveEntry{{"./", "dir"}, {"./a.txt", "a"}, {"./sub/", "dir"}, {"./sub/b.txt", "b"}}),
+			0, 0, http.StatusOK, []string{"d1/a.txt", "d1/sub/b.txt"}, nil, 0},
+		{"format=tgz", gzipped(makeTar([]archiveEntry{{"c.txt", "c"}})),
+			0, 0, http.StatusOK, []string{"d1/c.txt"}, nil, 0},
+		{"name=a.zip", makeZip([]archiveEntry{{"z/z.txt", "z"}}),
+			0, 0, http.StatusOK, []string{"d1/z/z.txt"}, nil, 0},
+		{"format=tar", makeTar([]archiveEntry{{"../x.txt", "x"}}),
+			0, 0, http.StatusBadRequest, nil, []string{"www/x.txt"}, 0},
+		{"format=zip", makeZip([]archiveEntry{{"../../y.txt", "y"}}),
+			0, 0, http.StatusBadRequest, nil, []string{"y.txt", "www/y.txt"}, 0},
+		{"format=tar", makeTar([]archiveEntry{{"/abs/e.txt", "e"}}),
+			0, 0, http.StatusOK, []string{"d1/abs/e.txt"}, []string{"abs/e.txt"}, 0},
+		{"format=tar", makeTar([]archiveEntry{{"link", "->/etc/passwd"}, {"f.txt", "f"}}),
+			0, 0, http.StatusOK, []string{"d1/f.txt"}, []string{"www/d1/link"}, 1},
+		{"format=tar", makeTar([]archiveEntry{{"big.txt", strings.Repeat("x", 100)}}),
+			10, 0, http.StatusRequestEntityTooLarge, nil, []string{"www/d1/big.txt"}, 0},
+		{"format=tar", makeTar([]archiveEntry{{"n1", "1"}, {"n2", "2"}, {"n3", "3"}}),
+			0, 2, http.StatusRequestEntityTooLarge, []string{"d1/n1", "d1/n2"}, []string{"www/d1/n3"}, 0},
+	}
+	for _, v := range tests {
+		os.RemoveAll(filepath.Join(root, "www"))
+		os.MkdirAll(filepath.Join(root, "www", "d1"), 0755)
+		s.ExtractMaxSize, s.ExtractMaxFiles = v.maxSize, v.maxFiles
+		req := httptest.NewRequest("POST", "/-/extract/d1?"+v.query, bytes.NewReader(v.body))
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != v.code {
+			t.Fatalf("Failed: %v - code:%d %s", v.query, w.Code, w.Body.String())
+		}
+		for _, name := range v.exists {
+			if !isFile(filepath.Join(root, "www", name)) {
+				t.Fatalf("Failed: %v - %s not extracted", v.query, name)
+			}
+		}
+		for _, name := range v.missing {
+			if _, err := os.Lstat(filepath.Join(root, name)); err == nil {
+				t.Fatalf("Failed: %v - %s should not exist", v.query, name)
+			}
+		}
+		var ret struct{ Skipped []string }
+		json.Unmarshal(w.Body.Bytes(), &ret)
+		if len(ret.Skipped) != v.skipped {
+			t.Fatalf("Failed: %v - skipped:%v", v.query, ret.Skipped)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+// Move, rename and copy of files and directories.
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cleanRequestPath cleans path given in form values, which must stay inside root and out of the internal directory
+func cleanRequestPath(name string) (string, error) {
+	if strings.TrimLeft(name, `/\`) == "" {
+		return "", newStatusError(http.StatusBadRequest, "Invalid path: root directory")
+	}
+	return uploadRelativePath(name)
+}
+
+// copyTree copies file or directory src to dst, symlinks are skipped as they could point outside of root
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.Mkdir(target, 0755)
+		case info.Mode().IsRegular():
+			if err = copyFile(path, target); err != nil {
+				return err
+			}
+			return os.Chmod(target, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+// checkOverwrite checks whether the existing dst could be replaced by a directory if isDir, or by a file.
+// Replacing removes dst, so delete permission of it is needed like DELETE, and a directory is never
+// replaced by a file. Nothing is checked if dst not exists.
+func (s *HTTPStaticServer) checkOverwrite(req *http.Request, dst string, isDir bool) error {
+	info, err := os.Lstat(filepath.Join(s.Root, dst))
+	if err != nil {
+		return nil
+	}
+	if info.IsDir() && !isDir {
+		return newStatusError(http.StatusConflict, "Destination %s is a directory", filepath.ToSlash(dst))
+	}
+	if auth := s.readAccessConf(dst); !auth.canDelete(req) {
+		return newStatusError(http.StatusForbidden, "Overwrite forbidden: no delete permission of %s", filepath.ToSlash(dst))
+	}
+	return nil
+}
+
+// resolveDestination decides where item of src finally goes when dst is taken, following policy.
+// For overwrite the existing one is moved to trash, or removed if trash is disabled.
+// It should be called with fileWriteMu held.
+func (s *HTTPStaticServer) resolveDestination(req *http.Request, dst string, isDir bool, policy string) (string, error) {
+	dstPath := filepath.Join(s.Root, dst)
+	info, err := os.Lstat(dstPath)
+	if os.IsNotExist(err) {
+		return dstPath, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	switch policy {
+	case conflictOverwrite:
+		// checked again, dst could be created after checkMoveOrCopy
+		if err = s.checkOverwrite(req, dst, isDir); err != nil {
+			return "", err
+		}
+		user, ip := requestAuthor(req)
+		if !info.IsDir() {
+			if err = s.saveHistory(dst, "delete", user, ip); err != nil {
+				return "", err
+			}
+		}
+		if s.TrashDays > 0 {
+			_, err = s.moveToTrash(dst, user, ip)
+		} else {
+			err = os.RemoveAll(dstPath)
+		}
+		return dstPath, err
+	case conflictReject:
+		return "", newStatusError(http.StatusConflict, "Destination already exists: %s", dst)
+	}
+	now := time.Now()
+	for i := 1; i < 1000; i++ {
+		candidate := conflictName(dstPath, policy, i, now)
+		if _, err = os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate, nil
+		}
+	}
+	return "", newStatusError(http.StatusConflict, "Too many files named like %s", dst)
+}
+
+// hMoveOrCopy moves or copies "src" to "dst", both are paths from root
+func (s *HTTPStaticServer) hMoveOrCopy(w http.ResponseWriter, req *http.Request) {
+	move := strings.HasPrefix(req.URL.Path, "/-/move")
+	src, err := cleanRequestPath(req.FormValue("src"))
+	var dst string
+	if err == nil {
+		dst, err = cleanRequestPath(req.FormValue("dst"))
+	}
+	if err == nil {
+		dst, err = s.moveOrCopy(req, src, dst, move, requestedConflict(req))
+	}
+	if err != nil {
+		log.Printf("Move or copy %s: %v", src, err)
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"src":     src,
+		"dst":     dst,
+	})
+}
+
+// checkMoveOrCopy checks permissions of moving or copying src to dst, and returns the conflict policy of dst.
+// Move needs delete permission of the source like DELETE, both need the source readable, and both need
+// upload permission of the destination directory. Overwriting an existing dst needs delete permission of it.
+func (s *HTTPStaticServer) checkMoveOrCopy(req *http.Request, src, dst string, move bool, requested string) (string, error) {
+	// paths hidden by accessTables are treated as not existing
+	if !s.accessible(src) {
+		return "", newStatusError(http.StatusNotFound, "Source not exists: %s", src)
+	}
+	if !s.accessible(dst) {
+		return "", newStatusError(http.StatusNotFound, "Destination not found: %s", dst)
+	}
+	if err := s.checkAccessConfWrite(req, src); err != nil {
+		return "", err
+	}
+	if err := s.checkAccessConfWrite(req, dst); err != nil {
+		return "", err
+	}
+	srcAuth := s.readAccessConf(src)
+	if move && !srcAuth.canDelete(req) {
+		return "", newStatusError(http.StatusForbidden, "Move forbidden: no delete permission of %s", src)
+	}
+	if !srcAuth.canRead(req) {
+		return "", newStatusError(http.StatusForbidden, "Read forbidden: %s", src)
+	}
+	dstDir := filepath.Dir(dst)
+	dstAuth := s.readAccessConf(dstDir)
+	if !dstAuth.canUpload(req) {
+		return "", newStatusError(http.StatusForbidden, "Upload forbidden: %s", filepath.ToSlash(dstDir))
+	}
+	if dst == src || strings.HasPrefix(dst, src+"/") {
+		return "", newStatusError(http.StatusBadRequest, "Destination %s is the source or inside it", dst)
+	}
+	policy, err := conflictPolicy(requested, dstAuth)
+	if err == nil && policy == conflictOverwrite {
+		info, statErr := os.Lstat(filepath.Join(s.Root, src))
+		err = s.checkOverwrite(req, dst, statErr == nil && info.IsDir())
+	}
+	return policy, err
+}
+
+// moveOrCopy moves or copies file or directory src to dst, and returns the path finally stored
+func (s *HTTPStaticServer) moveOrCopy(req *http.Request, src, dst string, move bool, requested string) (string, error) {
+	srcInfo, err := os.Lstat(filepath.Join(s.Root, src))
+	if err != nil {
+		return "", newStatusError(http.StatusNotFound, "Source not exists: %s", src)
+	}
+	if dstDir := filepath.Dir(dst); !isDir(filepath.Join(s.Root, dstDir)) {
+		return "", newStatusError(http.StatusConflict, "Destination directory not exists: %s", filepath.ToSlash(dstDir))
+	}
+	policy, err := s.checkMoveOrCopy(req, src, dst, move, requested)
+	if err != nil {
+		return "", err
+	}
+	// copy to a temp name in the destination directory, which is renamed into place like moving
+	from := filepath.Join(s.Root, src)
+	if !move {
+		suffix := make([]byte, 8)
+		rand.Read(suffix)
+		from = filepath.Join(s.Root, filepath.Dir(dst), uploadTempPrefix+hex.EncodeToString(suffix))
+		if err = copyTree(filepath.Join(s.Root, src), from); err != nil {
+			os.RemoveAll(from)
+			return "", err
+		}
+	}
+
+	fileWriteMu.Lock()
+	dstPath, err := s.resolveDestination(req, dst, srcInfo.IsDir(), policy)
+	if err == nil {
+		err = os.Rename(from, dstPath)
+	}
+	fileWriteMu.Unlock()
+	invalidateAccessConf()
+	if err != nil {
+		if !move {
+			os.RemoveAll(from)
+		}
+		return "", err
+	}
+	relPath, _ := filepath.Rel(s.Root, dstPath)
+	return filepath.ToSlash(relPath), nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMoveOrCopy(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	s := NewHTTPStaticServer(root)
+	files := map[string]string{
+		"src/.ghs.yml":       "upload: true\ndelete: true\n",
+		"src/a.txt":          "a",
+		"src/d/x.txt":        "x",
+		"ro/.ghs.yml":        "upload: false\ndelete: false\n",
+		"ro/r.txt":           "r",
+		"dst/.ghs.yml":       "upload: true\ndelete: false\n",
+		"dst/old.txt":        "old",
+		"dst/important/i":    "i",
+		"open/.ghs.yml":      "upload: true\ndelete: true\n",
+		"open/old.txt":       "old",
+		"open/dir/y.txt":     "y",
+		"open/dir/sub/z.txt": "z",
+	}
+
+	tests := []struct {
+		url     string
+		body    string
+		code    int
+		dst     string
+		content string   // of dst
+		missing []string // from root
+	}{
+		{"/-/copy", "src=src/a.txt&dst=dst/new.txt", http.StatusOK, "dst/new.txt", "a", nil},
+		{"/-/move", "src=src/a.txt&dst=dst/new.txt", http.StatusOK, "dst/new.txt", "a", []string{"src/a.txt"}},
+		// move removes the source, so it needs delete permission of it
+		{"/-/move", "src=ro/r.txt&dst=dst/new.txt", http.StatusForbidden, "", "", []string{"dst/new.txt"}},
+		{"/-/copy", "src=ro/r.txt&dst=dst/new.txt", http.StatusOK, "dst/new.txt", "r", nil},
+		{"/-/copy", "src=src/a.txt&dst=ro/new.txt", http.StatusForbidden, "", "", []string{"ro/new.txt"}},
+		{"/-/copy", "src=src/a.txt&dst=nodir/new.txt", http.StatusConflict, "", "", nil},
+		{"/-/move", "src=src/d&dst=src/d/sub", http.StatusBadRequest, "", "", nil},
+		// overwrite removes the destination, so it needs delete permission of it
+		{"/-/copy", "src=src/a.txt&dst=dst/old.txt", http.StatusForbidden, "", "", nil},
+		{"/-/copy", "src=src/a.txt&dst=dst/important", http.StatusConflict, "", "", nil},
+		{"/-/copy", "src=src/a.txt&dst=open/dir", http.StatusConflict, "", "", nil},
+		{"/-/copy?onConflict=rename", "src=src/a.txt&dst=dst/old.txt", http.StatusOK, "dst/old (1).txt", "a", nil},
+		{"/-/copy", "src=src/a.txt&dst=open/old.txt", http.StatusOK, "open/old.txt", "a", nil},
+		{"/-/move", "src=src/d&dst=open/dir", http.StatusOK, "open/dir/x.txt", "x", []string{"src/d", "open/dir/y.txt"}},
+		{"/-/copy?onConflict=reject", "src=src/a.txt&dst=open/old.txt", http.StatusConflict, "", "", nil},
+	}
+	for _, v := range tests {
+		os.RemoveAll(root)
+		for name, content := range files {
+			os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0755)
+			ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0644)
+		}
+		invalidateAccessConf()
+		req := httptest.NewRequest("POST", v.url, strings.NewReader(v.body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != v.code {
+			t.Fatalf("Failed: %s %s - code:%d %s", v.url, v.body, w.Code, w.Body.String())
+		}
+		if v.dst != "" {
+			var ret struct{ Dst string }
+			json.Unmarshal(w.Body.Bytes(), &ret)
+			if ret.Dst != v.dst && !strings.HasPrefix(v.dst, ret.Dst+"/") {
+				t.Fatalf("Failed: %s %s - dst:%s", v.url, v.body, ret.Dst)
+			}
+			if data, _ := ioutil.ReadFile(filepath.Join(root, v.dst)); string(data) != v.content {
+				t.Fatalf("Failed: %s %s - content:%q", v.url, v.body, data)
+			}
+		}
+		for _, name := range v.missing {
+			if _, err := os.Lstat(filepath.Join(root, name)); err == nil {
+				t.Fatalf("Failed: %s %s - %s should not exist", v.url, v.body, name)
+			}
+		}
+		// nothing is lost when refused
+		if v.code != http.StatusOK {
+			for name, content := range files {
+				if data, _ := ioutil.ReadFile(filepath.Join(root, name)); string(data) != content {
+					t.Fatalf("Failed: %s %s - %s changed", v.url, v.body, name)
+				}
+			}
+		}
+	}
+}
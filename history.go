@@ -0,0 +1,390 @@
+package main
+
+// Version history of files, previous content is kept when a file is replaced or removed by the server.
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultHistoryKeep = 20
+	maxDiffSize        = 1 << 20 // files larger than it are not diffed
+)
+
+type HistoryVersion struct {
+	ID      string `json:"id"`
+	Path    string `json:"path"`
+	Action  string `json:"action"` // upload, edit, delete or restore, the change which replaced this version
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"` // milliseconds, modification time of the content
+	User    string `json:"user,omitempty"`
+	IP      string `json:"ip,omitempty"`
+	Time    int64  `json:"time"` // milliseconds, when it was replaced
+}
+
+type byVersionTime []HistoryVersion
+
+func (vs byVersionTime) Len() int           { return len(vs) }
+func (vs byVersionTime) Swap(i, j int)      { vs[i], vs[j] = vs[j], vs[i] }
+func (vs byVersionTime) Less(i, j int) bool { return vs[i].Time > vs[j].Time }
+
+func (s *HTTPStaticServer) historyRoot() string {
+	return filepath.Join(s.Root, internalDir, "history")
+}
+
+// historyDir is named by hash of the file path, so names of files never collide with versions
+func (s *HTTPStaticServer) historyDir(path string) string {
+	path = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "/")
+	sum := sha1.Sum([]byte(path))
+	id := hex.EncodeToString(sum[:])
+	return filepath.Join(s.historyRoot(), id[:2], id)
+}
+
+func requestAuthor(req *http.Request) (user, ip string) {
+	if u := currentUser(req); u != nil {
+		user = u.Email
+	}
+	return user, getRealIP(req)
+}
+
+// saveHistory keeps the current content of file path as a version before it is replaced or removed.
+// Nothing is saved if the file not exists or history is disabled by "historyKeep: -1".
+func (s *HTTPStaticServer) saveHistory(path, action, user, ip string) error {
+	localPath := filepath.Join(s.Root, path)
+	info, err := os.Lstat(localPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	auth := s.readAccessConf(path)
+	if auth.HistoryKeep < 0 {
+		return nil
+	}
+	dir := s.historyDir(path)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	now := time.Now()
+	v := HistoryVersion{
+		ID:      strconv.FormatInt(now.UnixNano(), 10),
+		Path:    strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "/"),
+		Action:  action,
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano() / 1e6,
+		User:    user,
+		IP:      ip,
+		Time:    now.UnixNano() / 1e6,
+	}
+	dataPath := filepath.Join(dir, v.ID)
+	// the server replaces files by rename, so the old content is only referenced by the link afterwards
+	if err = os.Link(localPath, dataPath); err != nil {
+		if err = copyFile(localPath, dataPath); err != nil {
+			return err
+		}
+	}
+	data, _ := json.Marshal(v)
+	if err = ioutil.WriteFile(dataPath+".json", data, 0644); err != nil {
+		os.Remove(dataPath)
+		return err
+	}
+	s.pruneHistory(path, auth)
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// listHistory returns versions of file path, the newest first
+func (s *HTTPStaticServer) listHistory(path string) ([]HistoryVersion, error) {
+	dir := s.historyDir(path)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	versions := make([]HistoryVersion, 0, len(infos)/2)
+	for _, info := range infos {
+		if filepath.Ext(info.Name()) != ".json" {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			continue
+		}
+		var v HistoryVersion
+		if json.Unmarshal(data, &v) == nil {
+			versions = append(versions, v)
+		}
+	}
+	sort.Sort(byVersionTime(versions))
+	return versions, nil
+}
+
+// pruneHistory removes versions of file path exceed "historyKeep" or older than "historyDays"
+func (s *HTTPStaticServer) pruneHistory(path string, auth AccessConf) {
+	keep := auth.HistoryKeep
+	if keep == 0 {
+		keep = defaultHistoryKeep
+	}
+	versions, err := s.listHistory(path)
+	if err != nil {
+		return
+	}
+	dir := s.historyDir(path)
+	expire := time.Now().AddDate(0, 0, -auth.HistoryDays).UnixNano() / 1e6
+	for i, v := range versions {
+		if i >= keep || keep < 0 || (auth.HistoryDays > 0 && v.Time < expire) {
+			os.Remove(filepath.Join(dir, v.ID+".json"))
+			os.Remove(filepath.Join(dir, v.ID))
+		}
+	}
+	os.Remove(dir) // only removed when empty
+}
+
+// cleanHistory applies retention to versions of all files, including the deleted ones
+func (s *HTTPStaticServer) cleanHistory() {
+	dirs, _ := filepath.Glob(filepath.Join(s.historyRoot(), "*", "*"))
+	for _, dir := range dirs {
+		// saveHistory creates the directory before linking a version into it
+		fileWriteMu.Lock()
+		s.cleanHistoryDir(dir)
+		fileWriteMu.Unlock()
+	}
+}
+
+func (s *HTTPStaticServer) cleanHistoryDir(dir string) {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(matches) == 0 {
+		os.Remove(dir)
+		return
+	}
+	data, err := ioutil.ReadFile(matches[0])
+	var v HistoryVersion
+	if err != nil || json.Unmarshal(data, &v) != nil {
+		return
+	}
+	s.pruneHistory(v.Path, s.readAccessConf(v.Path))
+}
+
+func findVersion(versions []HistoryVersion, id string) *HistoryVersion {
+	for i := range versions {
+		if versions[i].ID == id {
+			return &versions[i]
+		}
+	}
+	return nil
+}
+
+// readDiffText reads text file for diff, an absent file is empty
+func readDiffText(path string) (string, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.Size() > maxDiffSize {
+		return "", newStatusError(http.StatusRequestEntityTooLarge, "File is too large to diff: %d bytes", info.Size())
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(data) || strings.IndexByte(string(data), 0) >= 0 {
+		return "", newStatusError(http.StatusUnsupportedMediaType, "Binary file can not be diffed")
+	}
+	return string(data), nil
+}
+
+// hHistory lists versions of file, or serves version given by "version",
+// or unified diff of version "diff" to version "to" (default current file)
+func (s *HTTPStaticServer) hHistory(w http.ResponseWriter, req *http.Request) {
+	path := mux.Vars(req)["path"]
+	localPath := filepath.Join(s.Root, path)
+	if !s.checkRead(w, req, path) {
+		return
+	}
+	versions, err := s.listHistory(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	versionPath := func(id string) (string, error) {
+		if id == "current" {
+			return localPath, nil
+		}
+		if findVersion(versions, id) == nil {
+			return "", newStatusError(http.StatusNotFound, "Version not found: %s", id)
+		}
+		return filepath.Join(s.historyDir(path), id), nil
+	}
+
+	if from := req.FormValue("diff"); from != "" {
+		to := req.FormValue("to")
+		if to == "" {
+			to = "current"
+		}
+		var texts [2]string
+		for i, id := range []string{from, to} {
+			p, err := versionPath(id)
+			if err == nil {
+				texts[i], err = readDiffText(p)
+			}
+			if err != nil {
+				httpError(w, err)
+				return
+			}
+		}
+		name := "/" + strings.TrimPrefix(filepath.ToSlash(path), "/")
+		diff, err := unifiedDiff("a"+name+"\t"+from, "b"+name+"\t"+to, texts[0], texts[1], 3)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain;charset=utf-8")
+		w.Write([]byte(diff))
+		return
+	}
+
+	if id := req.FormValue("version"); id != "" {
+		p, err := versionPath(id)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if req.FormValue("download") == "true" {
+			w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(filepath.Base(path)))
+		}
+		http.ServeContent(w, req, filepath.Base(path), info.ModTime(), f)
+		return
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"path":     filepath.ToSlash(path),
+		"current":  statFileVersion(localPath),
+		"versions": versions,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// hHistoryRestore replaces file with version "version", the replaced content is kept in history too
+func (s *HTTPStaticServer) hHistoryRestore(w http.ResponseWriter, req *http.Request) {
+	path := mux.Vars(req)["path"]
+	if !s.accessible(path) {
+		http.NotFound(w, req)
+		return
+	}
+	localPath := filepath.Join(s.Root, path)
+	auth := s.readAccessConf(path)
+	if !auth.canUpload(req) {
+		http.Error(w, "Restore forbidden", http.StatusForbidden)
+		return
+	}
+	if err := s.checkAccessConfWrite(req, path); err != nil {
+		httpError(w, err)
+		return
+	}
+	if !isDir(filepath.Dir(localPath)) {
+		http.Error(w, "Restore failed: directory not exists "+filepath.ToSlash(filepath.Dir(path)), http.StatusConflict)
+		return
+	}
+	if isFile(localPath) && auth.OnConflict != "" && auth.OnConflict != conflictOverwrite {
+		http.Error(w, "Restore failed: file exists and directory does not allow overwrite", http.StatusConflict)
+		return
+	}
+	versions, err := s.listHistory(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id := req.FormValue("version")
+	if findVersion(versions, id) == nil {
+		http.Error(w, "Restore failed: version not found "+strconv.Quote(id), http.StatusNotFound)
+		return
+	}
+	if err = checkPreconditions(localPath, req.Header); err != nil {
+		httpError(w, err)
+		return
+	}
+
+	src, err := os.Open(filepath.Join(s.historyDir(path), id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer src.Close()
+	dst, err := newAtomicFile(filepath.Dir(localPath))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Abort()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user, ip := requestAuthor(req)
+	fileWriteMu.Lock()
+	err = checkPreconditions(localPath, req.Header)
+	if err == nil {
+		err = s.saveHistory(path, "restore", user, ip)
+	}
+	if err != nil {
+		dst.Abort()
+	} else {
+		_, err = dst.Commit(localPath, conflictOverwrite)
+	}
+	fileWriteMu.Unlock()
+	if err != nil {
+		log.Println("Restore file:", err)
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"version": id,
+		"current": statFileVersion(localPath),
+	})
+}
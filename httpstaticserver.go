@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
@@ -11,6 +12,8 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -46,9 +49,18 @@ type HTTPStaticServer struct {
 	PlistProxy      string
 	GoogleTrackerId string
 	AuthType        string
-
-	indexes []IndexFileItem
-	m       *mux.Router
+	UploadExpire    time.Duration
+	ExtractMaxSize  int64 // bytes extracted from an archive
+	ExtractMaxFiles int
+	TrashDays       int                      // deleted items are kept in trash for days
+	ACLTemplates    map[string]yaml.MapSlice // written to ".ghs.yml" of new directories
+	MkdirTemplate   string                   // ACL template used when mkdir gives none
+	Groups          map[string][]string      `json:"-"` // group name to emails or "*@domain"
+	Admins          []string                 `json:"-"` // emails or "*@domain" who could edit ".ghs.yml" by /-/acl
+
+	indexes  []IndexFileItem
+	m        *mux.Router
+	aclCache accessConfCache
 }
 
 func NewHTTPStaticServer(root string) *HTTPStaticServer {
@@ -62,9 +74,13 @@ func NewHTTPStaticServer(root string) *HTTPStaticServer {
 	log.Printf("root path: %s\n", root)
 	m := mux.NewRouter()
 	s := &HTTPStaticServer{
-		Root:  root,
-		Theme: "black",
-		m:     m,
+		Root:            root,
+		Theme:           "black",
+		UploadExpire:    24 * time.Hour,
+		ExtractMaxSize:  1 << 30,
+		ExtractMaxFiles: 10000,
+		TrashDays:       30,
+		m:               m,
 	}
 
 	go func() {
@@ -79,6 +95,15 @@ func NewHTTPStaticServer(root string) *HTTPStaticServer {
 		}
 	}()
 
+	go func() {
+		for {
+			s.cleanTusUploads()
+			s.cleanHistory()
+			s.cleanTrash()
+			time.Sleep(time.Minute * 10)
+		}
+	}()
+
 	m.HandleFunc("/-/status", s.hStatus)
 	m.HandleFunc("/-/zip/{path:.*}", s.hZip)
 	m.HandleFunc("/-/unzip/{zip_path:.*}/-/{path:.*}", s.hUnzip)
@@ -87,12 +112,37 @@ func NewHTTPStaticServer(root string) *HTTPStaticServer {
 	m.HandleFunc("/-/mkdir/{path:.*}", s.hMkdir).Methods("POST")
 	// routers for checkout directory
 	m.HandleFunc("/-/checkout/{path:.*}", s.hCheckoutDir).Methods("GET", "HEAD")
+	m.HandleFunc("/-/checkout/{path:.*}", s.hCheckoutPromote).Methods("POST")
+	m.HandleFunc("/-/rollback/{path:.*}", s.hCheckoutRollback).Methods("POST")
+	m.HandleFunc("/-/channel/{path:.*}", s.hChannelHistory).Methods("GET")
+	m.HandleFunc("/-/update/{path:.*}", s.hCheckUpdate).Methods("GET")
+	m.HandleFunc("/-/rollout/{path:.*}", s.hRollout).Methods("POST")
 	// routers for Apple *.ipa
 	m.HandleFunc("/-/ipa/plist/{path:.*}", s.hPlist)
 	m.HandleFunc("/-/ipa/link/{path:.*}", s.hIpaLink)
 
 	// TODO: /ipa/info
 	m.HandleFunc("/-/info/{path:.*}", s.hInfo)
+	// routers for resumable uploads (tus protocol)
+	m.HandleFunc("/-/extract/{path:.*}", s.hExtract).Methods("POST")
+	m.HandleFunc("/-/history/{path:.*}", s.hHistory).Methods("GET", "HEAD")
+	m.HandleFunc("/-/history/{path:.*}", s.hHistoryRestore).Methods("POST")
+	m.HandleFunc("/-/access/{path:.*}", s.hAccess).Methods("GET")
+	m.HandleFunc("/-/acl/{path:.*}", s.hACL).Methods("GET")
+	m.HandleFunc("/-/acl/{path:.*}", s.hACLUpdate).Methods("PUT")
+	m.HandleFunc("/-/batch", s.hBatch).Methods("POST")
+	m.HandleFunc("/-/batch/zip", s.hBatchZip).Methods("POST")
+	m.HandleFunc("/-/move", s.hMoveOrCopy).Methods("POST")
+	m.HandleFunc("/-/copy", s.hMoveOrCopy).Methods("POST")
+	m.HandleFunc("/-/trash/-/{id}", s.hTrashRestore).Methods("POST")
+	m.HandleFunc("/-/trash/-/{id}", s.hTrashPurge).Methods("DELETE")
+	m.HandleFunc("/-/trash/{path:.*}", s.hTrashList).Methods("GET")
+	m.HandleFunc("/-/tus/-/{id}", s.hTusOptions).Methods("OPTIONS")
+	m.HandleFunc("/-/tus/-/{id}", s.hTusHead).Methods("HEAD")
+	m.HandleFunc("/-/tus/-/{id}", s.hTusPatch).Methods("PATCH")
+	m.HandleFunc("/-/tus/-/{id}", s.hTusDelete).Methods("DELETE")
+	m.HandleFunc("/-/tus/{path:.*}", s.hTusOptions).Methods("OPTIONS")
+	m.HandleFunc("/-/tus/{path:.*}", s.hTusCreate).Methods("POST")
 	// routers for listing (directory or files) / uploading / deleting files
 	m.HandleFunc("/{path:.*}", s.hIndex).Methods("GET", "HEAD")
 	m.HandleFunc("/{path:.*}", s.hUpload).Methods("POST")
@@ -103,12 +153,22 @@ func NewHTTPStaticServer(root string) *HTTPStaticServer {
 }
 
 func (s *HTTPStaticServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// server data is never exposed
+	for _, part := range strings.Split(r.URL.Path, "/") {
+		if part == internalDir {
+			http.NotFound(w, r)
+			return
+		}
+	}
 	s.m.ServeHTTP(w, r)
 }
 
 func (s *HTTPStaticServer) hIndex(w http.ResponseWriter, r *http.Request) {
 	path := mux.Vars(r)["path"]
 	relPath := filepath.Join(s.Root, path)
+	if !s.checkRead(w, r, path) {
+		return
+	}
 
 	if r.FormValue("raw") == "false" || isDir(relPath) {
 		if r.Method == "HEAD" {
@@ -119,6 +179,8 @@ func (s *HTTPStaticServer) hIndex(w http.ResponseWriter, r *http.Request) {
 		if r.FormValue("download") == "true" {
 			w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(filepath.Base(path)))
 		}
+		// conditional GET is handled by ServeFile with the etag
+		setETag(w, relPath)
 		http.ServeFile(w, r, relPath)
 	}
 }
@@ -129,50 +191,113 @@ func (s *HTTPStaticServer) hStatus(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
-// create function to HTTPStaticServer for making directory
+// hMkdir creates directory "folderName" in path, which may be nested like "a/b/c".
+// Missing parents are created too with "parents=true", like mkdir -p.
+// New directories inherit access of their parent, unless an ACL template is given by "template"
+// or by --mkdir-template, which is written to the ".ghs.yml" of the last one.
 func (s *HTTPStaticServer) hMkdir(w http.ResponseWriter, req *http.Request) {
 	path := mux.Vars(req)["path"]
-	auth := s.readAccessConf(path)
-	log.Printf("%#v", auth)
+	folderName := req.FormValue("folderName")
+	if strings.TrimLeft(folderName, `/\`) == "" {
+		http.Error(w, "Mkdir failed: empty folder name", http.StatusBadRequest)
+		return
+	}
+	relPath, err := uploadRelativePath(filepath.Join(path, folderName))
+	if err == nil && !s.accessible(relPath) {
+		err = newStatusError(http.StatusNotFound, "Not found: %s", relPath)
+	}
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	templateData, err := s.mkdirTemplate(req.FormValue("template"))
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	// directories created inherit access of the nearest existing one
+	existing, err := s.existingParent(relPath)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	auth := s.readAccessConf(existing)
 	if !auth.canMKDir(req) {
 		http.Error(w, "Mkdir forbidden", http.StatusForbidden)
 		return
 	}
-	// get folder name from request Body
-	folderName := req.FormValue("folderName")
-	folder := filepath.Join(s.Root, path, folderName)
-	err := os.Mkdir(folder, 0731) // wxr-xr-x
-	if err != nil {
-		// if folder already exists
-		if os.IsExist(err) {
-			http.Error(w, "Mkdir forbidden: directory already exists", http.StatusForbidden)
-		} else {
-			http.Error(w, err.Error(), 500)
+	if existing != filepath.Dir(relPath) && req.FormValue("parents") != "true" {
+		http.Error(w, "Mkdir failed: directory not exists "+filepath.ToSlash(filepath.Dir(relPath)), http.StatusConflict)
+		return
+	}
+	folder := filepath.Join(s.Root, relPath)
+	if info, err := os.Lstat(folder); err == nil {
+		// like mkdir -p, an existing directory is fine
+		if info.IsDir() && req.FormValue("parents") == "true" {
+			w.Write([]byte("Success"))
+			return
 		}
+		http.Error(w, "Mkdir failed: "+filepath.ToSlash(relPath)+" already exists", http.StatusConflict)
+		return
+	}
+	if err = makeDir(folder, templateData); err != nil {
+		log.Println("Mkdir:", err)
+		http.Error(w, "Mkdir failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	// create default auth file
-	cfgFile := filepath.Join(folder, ".ghs.yml")
-	file, _ := os.Create(cfgFile)
-	file.WriteString("upload: true\ndelete: true\nmkdir: false")
-	defer file.Close()
-
 	w.Write([]byte("Success"))
 }
 
+// mkdirTemplate returns content of ".ghs.yml" for new directories, of ACL template name
+// or --mkdir-template if name is empty. It is nil if there is no template.
+func (s *HTTPStaticServer) mkdirTemplate(name string) ([]byte, error) {
+	if name == "" {
+		name = s.MkdirTemplate
+	}
+	if name == "" {
+		return nil, nil
+	}
+	return s.aclTemplate(name)
+}
+
+// makeDir creates directory folder with parents, and writes templateData as its ".ghs.yml" if not nil
+func makeDir(folder string, templateData []byte) error {
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return err
+	}
+	if templateData == nil {
+		return nil
+	}
+	if err := ioutil.WriteFile(filepath.Join(folder, ".ghs.yml"), templateData, 0644); err != nil {
+		os.RemoveAll(folder)
+		return err
+	}
+	invalidateAccessConf()
+	return nil
+}
+
+// aclTemplate returns content of ".ghs.yml" for ACL template name
+func (s *HTTPStaticServer) aclTemplate(name string) ([]byte, error) {
+	conf, ok := s.ACLTemplates[name]
+	if !ok {
+		return nil, newStatusError(http.StatusBadRequest, "Unknown ACL template: %s", name)
+	}
+	return yaml.Marshal(conf)
+}
+
 // unchecked files in folder regex
 var uncheckedFileRegx, _ = regexp.Compile(".*\\.(yml|md)$")
 
 // create function to HTTPStaticServer for checking out directory
 // checout directory will return the latest modified file or file with obvious tag "checked" in ".ghs.yml"
+// when checkout mode is "semver", the file with highest version in name will be returned instead of the latest one
 func (s *HTTPStaticServer) hCheckoutDir(w http.ResponseWriter, req *http.Request) {
 	path := mux.Vars(req)["path"]
 	relPath := filepath.Join(s.Root, path)
-	testAuthPath := filepath.Join(path, ".ghs.yml") // TMP path to test loading auth config file
-	auth := s.readAccessConf(testAuthPath)
+	auth := s.readAccessConf(path)
 	log.Printf("%#v", auth)
-	if !auth.canAccess(path) {
-		http.Error(w, "Checkout forbidden", http.StatusForbidden)
+	if !s.checkRead(w, req, path) {
 		return
 	}
 	// if path is not directory
@@ -180,111 +305,192 @@ func (s *HTTPStaticServer) hCheckoutDir(w http.ResponseWriter, req *http.Request
 		http.Error(w, "Checkout forbidden: directory not exists "+path, http.StatusForbidden)
 		return
 	}
-	// get checkout tag in auth
-	checkedFileName := auth.Checked
-	if checkedFileName != "" {
-		checkedFilePath := filepath.Join(relPath, checkedFileName)
-		if isFile(checkedFilePath) {
-			// set header
-			w.Header().Set("Content-Disposition", "attachment; filename="+checkedFileName)
-			http.ServeFile(w, req, checkedFilePath)
-		} else {
-			http.Error(w, "Checkout forbidden: not valid file "+checkedFilePath, http.StatusForbidden)
-		}
-	} else {
-		// load all files in directory and get latest modified
-		fileInfos, err := ioutil.ReadDir(relPath)
-		// if can't list file or no file except the ".yml" or ".md" file
-		if err != nil || len(fileInfos) == 0 {
-			http.Error(w, "Checkout forbidden: no content in "+path, http.StatusForbidden)
-		} else {
-			var latestFileInfo os.FileInfo
-			for _, fileInfo := range fileInfos {
-				// ignore directory and ".yml" or ".md" file
-				if fileInfo.IsDir() || uncheckedFileRegx.MatchString(fileInfo.Name()) {
-					continue
-				}
-				if latestFileInfo == nil {
-					latestFileInfo = fileInfo
-				} else if fileInfo.ModTime().After(latestFileInfo.ModTime()) {
-					latestFileInfo = fileInfo
-				}
-			}
-			if latestFileInfo != nil {
-				// set header
-				w.Header().Set("Content-Disposition", "attachment; filename="+latestFileInfo.Name())
-				http.ServeFile(w, req, filepath.Join(relPath, latestFileInfo.Name()))
-			} else {
-				http.Error(w, "Checkout forbidden: no content in "+path, http.StatusForbidden)
-			}
+	setCheckoutVary(w, auth)
+	// long poll: ?wait=60s&after=<etag> holds request until resolved file changes
+	var timeout time.Duration
+	if wait := req.FormValue("wait"); wait != "" {
+		var err error
+		if timeout, err = parseWaitDuration(wait); err != nil {
+			http.Error(w, "Checkout failed: invalid wait "+strconv.Quote(wait), http.StatusBadRequest)
+			return
 		}
 	}
+	fileName, etag, err := s.waitCheckout(req, path, req.FormValue("after"), timeout)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	if timeout > 0 && etag == req.FormValue("after") {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	// set header
+	w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
+	http.ServeFile(w, req, filepath.Join(relPath, fileName))
 }
 
-// create function to HTTPStaticServer for editing file
+// hEdit stores request body as the file content, like "curl -T file"
 func (s *HTTPStaticServer) hEdit(w http.ResponseWriter, req *http.Request) {
-	// only can delete file now
 	path := mux.Vars(req)["path"]
+	if !s.accessible(path) {
+		http.NotFound(w, req)
+		return
+	}
 	auth := s.readAccessConf(path)
-	log.Printf("%#v", auth)
 	if !auth.canUpload(req) {
-		// user can edit file only if has upload authority
+		// user can create or edit file only if has upload authority
 		http.Error(w, "Edit forbidden: not authorized", http.StatusForbidden)
 		return
 	}
-	// get file content from request Body
-	fileContent := req.FormValue("content")
+	if err := s.checkAccessConfWrite(req, path); err != nil {
+		httpError(w, err)
+		return
+	}
 	localPath := filepath.Join(s.Root, path)
 	// if path is directory, can't edit
 	if isDir(localPath) {
-		http.Error(w, "Edit forbidden: directory can't be modified: "+localPath, http.StatusForbidden)
+		http.Error(w, "Edit forbidden: directory can't be modified: "+path, http.StatusForbidden)
 		return
 	}
-	// open file and over write
-	err := ioutil.WriteFile(localPath, []byte(fileContent), 0666)
+	if !isDir(filepath.Dir(localPath)) {
+		http.Error(w, "Edit failed: directory not exists "+filepath.ToSlash(filepath.Dir(path)), http.StatusConflict)
+		return
+	}
+	info, statErr := os.Stat(localPath)
+	exists := statErr == nil
+	// replacing is overwriting, which directory may not allow
+	if exists && auth.OnConflict != "" && auth.OnConflict != conflictOverwrite {
+		http.Error(w, "Edit failed: file exists and directory does not allow overwrite", http.StatusConflict)
+		return
+	}
+	expect, err := parseExpectedChecksums(req.Header)
+	if err == nil {
+		err = checkPreconditions(localPath, req.Header)
+	}
 	if err != nil {
-		// if open file fails
-		if !os.IsExist(err) {
-			http.Error(w, "Edit forbidden: file not exists", http.StatusForbidden)
-		} else {
-			http.Error(w, err.Error(), 500)
-		}
+		httpError(w, err)
 		return
 	}
-	w.Write([]byte("Success"))
+	// body is streamed to a temp file, and moved into place when complete
+	dst, err := newAtomicFile(filepath.Dir(localPath))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err = io.Copy(dst, req.Body); err != nil {
+		dst.Abort()
+		log.Println("Handle put file:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = dst.Verify(expect); err != nil {
+		dst.Abort()
+		httpError(w, err)
+		return
+	}
+	// file could be changed while receiving, preconditions are checked again
+	user, ip := requestAuthor(req)
+	fileWriteMu.Lock()
+	err = checkPreconditions(localPath, req.Header)
+	if err == nil {
+		err = s.saveHistory(path, "edit", user, ip)
+	}
+	if err != nil {
+		dst.Abort()
+	} else {
+		_, err = dst.Commit(localPath, conflictOverwrite)
+	}
+	fileWriteMu.Unlock()
+	if err != nil {
+		log.Println("Handle put file:", err)
+		httpError(w, err)
+		return
+	}
+	if exists {
+		os.Chmod(localPath, info.Mode().Perm()) // keep mode of the replaced file
+	}
+	setETag(w, localPath)
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	sums := dst.Checksums()
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.Header().Set("Location", "/"+filepath.ToSlash(path))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"destination": localPath,
+		"md5":         sums.MD5,
+		"sha256":      sums.Sha256,
+	})
 }
 
 func (s *HTTPStaticServer) hDelete(w http.ResponseWriter, req *http.Request) {
 	path := mux.Vars(req)["path"]
+	if !s.accessible(path) {
+		http.NotFound(w, req)
+		return
+	}
 	auth := s.readAccessConf(path)
 	log.Printf("%#v", auth)
 	if !auth.canDelete(req) {
 		http.Error(w, "Delete forbidden", http.StatusForbidden)
 		return
 	}
+	if err := s.checkAccessConfWrite(req, path); err != nil {
+		httpError(w, err)
+		return
+	}
 	localPath := filepath.Join(s.Root, path)
-	// delete single file
-	if isFile(localPath) {
-		err := os.Remove(localPath)
-		if err != nil {
-			http.Error(w, err.Error(), 500)
-			return
-		}
-	} else if isDir(localPath) {
-		// delete directory
-		err := os.RemoveAll(localPath)
-		if err != nil {
-			http.Error(w, err.Error(), 500)
+	if filepath.Clean(localPath) == filepath.Clean(s.Root) {
+		http.Error(w, "Delete forbidden: root directory", http.StatusForbidden)
+		return
+	}
+	user, ip := requestAuthor(req)
+	item, err := s.deletePath(path, user, ip, req.Header)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	if item != nil {
+		w.Header().Set("X-Trash-Id", item.ID)
+	}
+	w.Write([]byte("Success"))
+}
+
+// deletePath moves file or directory path to trash, unless disabled by --trash-days 0.
+// Nothing is done if path not exists.
+func (s *HTTPStaticServer) deletePath(path, user, ip string, header http.Header) (item *TrashItem, err error) {
+	localPath := filepath.Join(s.Root, path)
+	info, err := os.Lstat(localPath)
+	if err != nil {
+		return nil, nil
+	}
+	fileWriteMu.Lock()
+	defer fileWriteMu.Unlock()
+	defer invalidateAccessConf()
+	if err = checkPreconditions(localPath, header); err != nil {
+		return
+	}
+	if !info.IsDir() {
+		if err = s.saveHistory(path, "delete", user, ip); err != nil {
 			return
 		}
 	}
-
-	w.Write([]byte("Success"))
+	if s.TrashDays > 0 {
+		return s.moveToTrash(path, user, ip)
+	}
+	return nil, os.RemoveAll(localPath)
 }
 
 func (s *HTTPStaticServer) hUpload(w http.ResponseWriter, req *http.Request) {
 	path := mux.Vars(req)["path"]
-	dirpath := filepath.Join(s.Root, path)
+	if req.URL.Query().Get("extract") == "true" {
+		s.hExtract(w, req)
+		return
+	}
 
 	// check auth
 	auth := s.readAccessConf(path)
@@ -293,34 +499,73 @@ func (s *HTTPStaticServer) hUpload(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	file, header, err := req.FormFile("file")
+	// files are streamed to disk one by one, any number of files could be sent in one request
+	reader, err := req.MultipartReader()
 	if err != nil {
-		log.Println("Parse form file:", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Parse multipart form: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer func() {
-		file.Close()
-		req.MultipartForm.RemoveAll() // Seen from go source code, req.MultipartForm not nil after call FormFile(..)
-	}()
-	dstPath := filepath.Join(dirpath, header.Filename)
-	dst, err := os.Create(dstPath)
-	if err != nil {
-		log.Println("Create file:", err)
-		http.Error(w, "File create "+err.Error(), http.StatusInternalServerError)
-		return
+	results := make([]UploadResult, 0)
+	relativePath := ""
+	// checksums and preconditions of the request are only applied to a single file, other files are
+	// refused before the first one is stored
+	single := hasFileHeaders(req.Header)
+	lastPart := func() error {
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return newStatusError(http.StatusBadRequest, "Parse multipart form: %v", err)
+			}
+			if partFileName(part) != "" {
+				return newStatusError(http.StatusBadRequest, "Checksum and precondition headers of the request are for a single file, set them on each file part")
+			}
+			part.Close()
+		}
 	}
-	defer dst.Close()
-	if _, err := io.Copy(dst, file); err != nil {
-		log.Println("Handle upload file:", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Println("Parse multipart form:", err)
+			http.Error(w, "Parse multipart form: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		name := partFileName(part)
+		if name == "" {
+			// browser folder uploads send the path as a field before the file
+			if field := part.FormName(); field == "relativePath" || field == "fullPath" {
+				data, _ := ioutil.ReadAll(io.LimitReader(part, 4096))
+				relativePath = string(data)
+			}
+			part.Close()
+			continue
+		}
+		if relativePath != "" {
+			name, relativePath = relativePath, ""
+		}
+		// headers of the file part take precedence
+		if single {
+			results = append(results, s.saveUploadPart(req, path, name, part, []http.Header{http.Header(part.Header), req.Header}, lastPart))
+			part.Close()
+			break
+		}
+		results = append(results, s.saveUploadPart(req, path, name, part, []http.Header{http.Header(part.Header)}, nil))
+		part.Close()
+	}
+	if len(results) == 0 {
+		http.Error(w, "Upload failed: no file found in request", http.StatusBadRequest)
 		return
 	}
+
+	ret, code := uploadResponse(results)
 	w.Header().Set("Content-Type", "application/json;charset=utf-8")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success":     true,
-		"destination": dstPath,
-	})
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(ret)
 }
 
 type FileJSONInfo struct {
@@ -354,6 +599,9 @@ func parseApkInfo(path string) (ai *ApkInfo) {
 func (s *HTTPStaticServer) hInfo(w http.ResponseWriter, r *http.Request) {
 	path := mux.Vars(r)["path"]
 	relPath := filepath.Join(s.Root, path)
+	if !s.checkRead(w, r, path) {
+		return
+	}
 	if !isFile(relPath) {
 		http.Error(w, "Not a file", 403)
 		return
@@ -386,12 +634,47 @@ func (s *HTTPStaticServer) hInfo(w http.ResponseWriter, r *http.Request) {
 
 func (s *HTTPStaticServer) hZip(w http.ResponseWriter, r *http.Request) {
 	path := mux.Vars(r)["path"]
-	CompressToZip(w, filepath.Join(s.Root, path))
+	if !s.checkRead(w, r, path) {
+		return
+	}
+	CompressToZip(w, filepath.Join(s.Root, path), s.zipFilter(r))
+}
+
+// zipFilter skips the internal directory, entries not allowed by accessTables,
+// and subdirectories not readable by the user of request
+func (s *HTTPStaticServer) zipFilter(r *http.Request) func(path string, info os.FileInfo) bool {
+	dirAuths := make(map[string]AccessConf)
+	return func(path string, info os.FileInfo) bool {
+		if filepath.Base(path) == internalDir {
+			return false
+		}
+		relPath, err := filepath.Rel(s.Root, path)
+		if err != nil {
+			return false
+		}
+		dir := filepath.Dir(relPath)
+		dirAuth, ok := dirAuths[dir]
+		if !ok {
+			dirAuth = s.readAccessConf(dir)
+			dirAuths[dir] = dirAuth
+		}
+		if relPath != "." && !dirAuth.canAccess(relPath) {
+			return false
+		}
+		if !info.IsDir() {
+			return true
+		}
+		auth := s.readAccessConf(relPath)
+		return auth.canRead(r)
+	}
 }
 
 func (s *HTTPStaticServer) hUnzip(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	zipPath, path := vars["zip_path"], vars["path"]
+	if !s.checkRead(w, r, zipPath) {
+		return
+	}
 	ctype := mime.TypeByExtension(filepath.Ext(path))
 	if ctype != "" {
 		w.Header().Set("Content-Type", ctype)
@@ -421,6 +704,9 @@ func (s *HTTPStaticServer) hPlist(w http.ResponseWriter, r *http.Request) {
 	if filepath.Ext(path) == ".plist" {
 		path = path[0:len(path)-6] + ".ipa"
 	}
+	if !s.checkRead(w, r, path) {
+		return
+	}
 
 	relPath := filepath.Join(s.Root, path)
 	plinfo, err := parseIPA(relPath)
@@ -448,6 +734,9 @@ func (s *HTTPStaticServer) hPlist(w http.ResponseWriter, r *http.Request) {
 
 func (s *HTTPStaticServer) hIpaLink(w http.ResponseWriter, r *http.Request) {
 	path := mux.Vars(r)["path"]
+	if !s.checkRead(w, r, path) {
+		return
+	}
 	plistUrl := genURLStr(r, "/-/ipa/plist/"+path).String()
 	if r.TLS == nil {
 		// send plist to plistproxy and get a https link
@@ -515,104 +804,222 @@ type HTTPFileInfo struct {
 type AccessTable struct {
 	Regex string `yaml:"regex"`
 	Allow bool   `yaml:"allow"`
+	Match string `yaml:"match"` // "name" (default) matches the base name, "path" matches the path from root
 }
 
 type UserControl struct {
-	Email string
-	// Access bool
-	Upload bool // upload file
-	Delete bool // delete file
-	MKDir  bool // create dir
+	Email  string
+	Read   *bool // download and list, not set keeps the directory's
+	Upload bool  // upload file
+	Delete bool  // delete file
+	MKDir  bool  // create dir
+}
+
+// GroupControl is rule of a group defined by "groups" in server config, or given by identity provider
+type GroupControl struct {
+	Name   string
+	Read   *bool
+	Upload bool
+	Delete bool
+	MKDir  bool
 }
 
 type AccessConf struct {
-	Upload       bool          `yaml:"upload" json:"upload"`
-	Delete       bool          `yaml:"delete" json:"delete"`
-	MKDir        bool          `yaml:"mkdir" json:"mkdir"`
-	Checked      string        `yaml:"checked" json:"checked"`
-	Users        []UserControl `yaml:"users" json:"users"`
-	AccessTables []AccessTable `yaml:"accessTables"`
+	Upload          bool           `yaml:"upload" json:"upload"`
+	Delete          bool           `yaml:"delete" json:"delete"`
+	MKDir           bool           `yaml:"mkdir" json:"mkdir"`
+	Checked         string         `yaml:"checked" json:"checked"`
+	Checkout        string         `yaml:"checkout" json:"checkout"`                         // checkout mode, latest or semver
+	Platform        string         `yaml:"platform" json:"platform"`                         // checkout file pattern, e.g. foo-{os}-{arch}.tar.gz
+	DefaultPlatform string         `yaml:"defaultPlatform" json:"defaultPlatform,omitempty"` // platform of clients telling none, e.g. linux/amd64
+	Candidate       string         `yaml:"candidate" json:"candidate"`                       // file in staged rollout
+	Rollout         int            `yaml:"rollout" json:"rollout"`                           // percentage of clients getting candidate
+	RolloutPaused   bool           `yaml:"rolloutPaused" json:"rolloutPaused"`
+	OnConflict      string         `yaml:"onConflict" json:"onConflict"`   // overwrite, rename, version-suffix or reject
+	HistoryKeep     int            `yaml:"historyKeep" json:"historyKeep"` // versions kept per file, -1 disables history
+	HistoryDays     int            `yaml:"historyDays" json:"historyDays"` // versions older than it are removed
+	Read            *bool          `yaml:"read" json:"read,omitempty"`     // not set allows everyone
+	LoginRequired   bool           `yaml:"loginRequired" json:"loginRequired"`
+	Users           []UserControl  `yaml:"users" json:"users"`
+	Groups          []GroupControl `yaml:"groups" json:"groups"`
+	AccessTables    []AccessTable  `yaml:"accessTables"`
+	Ignore          []string       `yaml:"ignore" json:"ignore,omitempty"` // gitignore patterns, like the ".ghsignore" file
+	Warnings        []string       `yaml:"-" json:"warnings,omitempty"`    // problems found in ".ghs.yml" and ".ghsignore"
+
+	groupMembers map[string][]string
+	basicAuth    bool // everyone is logged in by HTTP basic auth
+	ignores      []*ignoreRules
 }
 
-var reCache = make(map[string]*regexp.Regexp)
+// accessDecision is the rule deciding a permission, as explained by /-/access
+type accessDecision struct {
+	Allowed bool   `json:"allowed"`
+	Rule    string `json:"rule"`             // user, group, default, loginRequired, ignore or accessTables
+	Match   string `json:"match,omitempty"`  // email, group names, pattern or regex of the rule
+	Source  string `json:"source,omitempty"` // file defining the rule, "server" for server config
+}
 
-func (c *AccessConf) canAccess(fileName string) bool {
+// canAccess reports whether entry path (from root) of the directory is allowed by ignore rules and accessTables
+func (c *AccessConf) canAccess(path string) bool {
+	return c.accessDecision(path).Allowed
+}
+
+// accessDecision returns the ignore rule or accessTables rule deciding entry path, Rule is empty if none matches
+func (c *AccessConf) accessDecision(path string) accessDecision {
+	path = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "/")
+	// rules of deeper directories take precedence
+	var ignored *ignoreRule
+	for _, rules := range c.ignores {
+		if rule := rules.matchRule(path); rule != nil {
+			ignored = rule
+		}
+	}
+	if ignored != nil && !ignored.negate {
+		return accessDecision{Rule: "ignore", Match: ignored.line, Source: ignored.source}
+	}
+	name := filepath.Base(path)
 	for _, table := range c.AccessTables {
-		pattern, ok := reCache[table.Regex]
-		if !ok {
-			pattern, _ = regexp.Compile(table.Regex)
-			reCache[table.Regex] = pattern
+		target := name
+		if table.Match == "path" {
+			target = path
 		}
-		// skip wrong format regex
+		pattern := compileRegex(table.Regex)
+		// skip wrong format regex, which is reported in warnings
 		if pattern == nil {
 			continue
 		}
-		if pattern.MatchString(fileName) {
-			return table.Allow
+		if pattern.MatchString(target) {
+			return accessDecision{Allowed: table.Allow, Rule: "accessTables", Match: table.Regex}
 		}
 	}
-	return true
+	return accessDecision{Allowed: true}
 }
 
-func (c *AccessConf) canDelete(r *http.Request) bool {
-	session, err := store.Get(r, defaultSessionName)
-	if err != nil {
-		return c.Delete
-	}
-	val := session.Values["user"]
-	if val == nil {
-		return c.Delete
-	}
-	userInfo := val.(*UserInfo)
-	for _, rule := range c.Users {
-		if rule.Email == userInfo.Email {
-			return rule.Delete
+// actions decided by AccessConf.decide
+const (
+	actionRead   = "read"
+	actionUpload = "upload"
+	actionDelete = "delete"
+	actionMKDir  = "mkdir"
+)
+
+// permitted returns whether a rule allows action, ok is false if the rule does not set it
+func permitted(action string, read *bool, upload, delete, mkdir bool) (allowed, ok bool) {
+	switch action {
+	case actionRead:
+		if read == nil {
+			return false, false
 		}
+		return *read, true
+	case actionUpload:
+		return upload, true
+	case actionDelete:
+		return delete, true
+	case actionMKDir:
+		return mkdir, true
 	}
-	return c.Delete
+	return false, false
 }
 
-func (c *AccessConf) canUpload(r *http.Request) bool {
-	session, err := store.Get(r, defaultSessionName)
-	if err != nil {
-		return c.Upload
+// inGroup reports whether user is member of group, by the groups of identity provider,
+// or by emails and "*@domain" patterns of group in server config
+func (c *AccessConf) inGroup(user *UserInfo, group string) bool {
+	for _, name := range user.Groups {
+		if name == group {
+			return true
+		}
 	}
-	val := session.Values["user"]
-	if val == nil {
-		return c.Upload
+	return memberMatch(c.groupMembers[group], user.Email)
+}
+
+// memberMatch reports whether email is one of members, which are emails or "*@domain"
+func memberMatch(members []string, email string) bool {
+	email = strings.ToLower(email)
+	if email == "" {
+		return false
 	}
-	userInfo := val.(*UserInfo)
-	for _, rule := range c.Users {
-		if rule.Email == userInfo.Email {
-			return rule.Upload
+	for _, member := range members {
+		member = strings.ToLower(member)
+		if member == email || (strings.HasPrefix(member, "*@") && strings.HasSuffix(email, member[1:])) {
+			return true
 		}
 	}
-	return c.Upload
+	return false
 }
 
-/* function can mkdir */
-func (c *AccessConf) canMKDir(r *http.Request) bool {
-	session, err := store.Get(r, defaultSessionName)
-	if err != nil {
-		return c.MKDir
-	}
-	val := session.Values["user"]
-	if val == nil {
-		return c.MKDir
+// decide returns whether the user of request could do action
+func (c *AccessConf) decide(r *http.Request, action string) bool {
+	return c.decision(currentUser(r), action).Allowed
+}
+
+// decision returns the rule deciding whether user (nil if anonymous) could do action. A rule of the user's email
+// takes precedence, then rules of groups the user is in, allowed if any of them allows.
+// Anyone else gets the default of directory.
+func (c *AccessConf) decision(user *UserInfo, action string) accessDecision {
+	defaultValue, ok := permitted(action, c.Read, c.Upload, c.Delete, c.MKDir)
+	if !ok {
+		defaultValue = true // read is allowed unless denied
 	}
-	userInfo := val.(*UserInfo)
-	for _, rule := range c.Users {
-		if rule.Email == userInfo.Email {
-			return rule.MKDir
+	if user != nil {
+		for _, rule := range c.Users {
+			if rule.Email == user.Email {
+				if allowed, ok := permitted(action, rule.Read, rule.Upload, rule.Delete, rule.MKDir); ok {
+					return accessDecision{Allowed: allowed, Rule: "user", Match: rule.Email}
+				}
+				break
+			}
+		}
+		var matched, allowing []string
+		for _, rule := range c.Groups {
+			if ruleAllowed, ok := permitted(action, rule.Read, rule.Upload, rule.Delete, rule.MKDir); ok && c.inGroup(user, rule.Name) {
+				matched = append(matched, rule.Name)
+				if ruleAllowed {
+					allowing = append(allowing, rule.Name)
+				}
+			}
+		}
+		if len(allowing) > 0 {
+			return accessDecision{Allowed: true, Rule: "group", Match: strings.Join(allowing, ",")}
+		}
+		if len(matched) > 0 {
+			return accessDecision{Allowed: false, Rule: "group", Match: strings.Join(matched, ",")}
 		}
 	}
-	return c.MKDir
+	return accessDecision{Allowed: defaultValue, Rule: "default"}
+}
+
+// canRead reports whether the user of request could download and list, anonymous users could not if login is required
+func (c *AccessConf) canRead(r *http.Request) bool {
+	return c.readDecision(currentUser(r)).Allowed
+}
+
+func (c *AccessConf) readDecision(user *UserInfo) accessDecision {
+	if c.LoginRequired && !c.basicAuth && user == nil {
+		return accessDecision{Rule: "loginRequired"}
+	}
+	return c.decision(user, actionRead)
+}
+
+func (c *AccessConf) canDelete(r *http.Request) bool {
+	return c.decide(r, actionDelete)
+}
+
+func (c *AccessConf) canUpload(r *http.Request) bool {
+	return c.decide(r, actionUpload)
+}
+
+/* function can mkdir */
+func (c *AccessConf) canMKDir(r *http.Request) bool {
+	return c.decide(r, actionMKDir)
 }
 
 func (s *HTTPStaticServer) hJSONList(w http.ResponseWriter, r *http.Request) {
 	requestPath := mux.Vars(r)["path"]
 	localPath := filepath.Join(s.Root, requestPath)
 	search := r.FormValue("search")
+	if !s.checkRead(w, r, requestPath) {
+		return
+	}
 	auth := s.readAccessConf(requestPath)
 	auth.Upload = auth.canUpload(r)
 	auth.Delete = auth.canDelete(r)
@@ -622,12 +1029,16 @@ func (s *HTTPStaticServer) hJSONList(w http.ResponseWriter, r *http.Request) {
 	fileInfoMap := make(map[string]os.FileInfo, 0)
 
 	if search != "" {
-		results := s.findIndex(search)
-		if len(results) > 50 { // max 50
-			results = results[:50]
-		}
-		for _, item := range results {
-			if filepath.HasPrefix(item.Path, requestPath) {
+		for _, item := range s.findIndex(search) {
+			if len(fileInfoMap) >= 50 { // max 50
+				break
+			}
+			if !filepath.HasPrefix(item.Path, requestPath) {
+				continue
+			}
+			// results are from anywhere below, each one is checked
+			itemAuth := s.readAccessConf(item.Path)
+			if s.accessible(item.Path) && itemAuth.canRead(r) {
 				fileInfoMap[item.Path] = item.Info
 			}
 		}
@@ -638,14 +1049,24 @@ func (s *HTTPStaticServer) hJSONList(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		for _, info := range infos {
-			fileInfoMap[filepath.Join(requestPath, info.Name())] = info
+			if info.Name() == internalDir {
+				continue
+			}
+			path := filepath.Join(requestPath, info.Name())
+			// files are readable with the directory, subdirectories may have their own rules
+			if info.IsDir() {
+				if dirAuth := s.readAccessConf(path); !dirAuth.canRead(r) {
+					continue
+				}
+			}
+			fileInfoMap[path] = info
 		}
 	}
 
 	// turn file list -> json
 	lrs := make([]HTTPFileInfo, 0)
 	for path, info := range fileInfoMap {
-		if !auth.canAccess(info.Name()) {
+		if !auth.canAccess(path) {
 			continue
 		}
 		lr := HTTPFileInfo{
@@ -673,9 +1094,18 @@ func (s *HTTPStaticServer) hJSONList(w http.ResponseWriter, r *http.Request) {
 		lrs = append(lrs, lr)
 	}
 
+	templates := make([]string, 0, len(s.ACLTemplates))
+	if auth.MKDir {
+		for name := range s.ACLTemplates {
+			templates = append(templates, name)
+		}
+		sort.Strings(templates)
+	}
 	data, _ := json.Marshal(map[string]interface{}{
-		"files": lrs,
-		"auth":  auth,
+		"files":        lrs,
+		"auth":         auth,
+		"aclTemplates": templates,
+		"admin":        s.isAdmin(currentUser(r)),
 	})
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(data)
@@ -692,6 +1122,9 @@ func (s *HTTPStaticServer) makeIndex() error {
 			// return err
 		}
 		if info.IsDir() {
+			if info.Name() == internalDir {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
@@ -747,37 +1180,250 @@ func (s *HTTPStaticServer) findIndex(text string) []IndexFileItem {
 
 func (s *HTTPStaticServer) defaultAccessConf() AccessConf {
 	return AccessConf{
-		Upload: s.Upload,
-		Delete: s.Delete,
-		MKDir:  s.MKDir,
+		Upload:       s.Upload,
+		Delete:       s.Delete,
+		MKDir:        s.MKDir,
+		groupMembers: s.Groups,
+		basicAuth:    s.AuthType == "http",
 	}
 }
 
-func (s *HTTPStaticServer) readAccessConf(requestPath string) (ac AccessConf) {
-	requestPath = filepath.Clean(requestPath)
-	if requestPath == "/" || requestPath == "" || requestPath == "." {
-		ac = s.defaultAccessConf()
-	} else {
-		parentPath := filepath.Dir(requestPath)
-		ac = s.readAccessConf(parentPath)
+// readAccessConf returns conf of directory requestPath, or the directory of file requestPath
+func (s *HTTPStaticServer) readAccessConf(requestPath string) AccessConf {
+	conf, _ := s.dirAccessConf(s.accessConfDir(requestPath))
+	return conf
+}
+
+// accessConfDir returns the directory (from root) whose conf applies to requestPath
+func (s *HTTPStaticServer) accessConfDir(requestPath string) string {
+	requestPath = filepath.Clean(strings.TrimPrefix(filepath.ToSlash(requestPath), "/"))
+	if requestPath != "." && isFile(filepath.Join(s.Root, requestPath)) {
+		requestPath = filepath.Dir(requestPath)
 	}
-	relPath := filepath.Join(s.Root, requestPath)
-	if isFile(relPath) {
-		relPath = filepath.Dir(relPath)
+	return requestPath
+}
+
+// layerAccessConf returns conf of directory dir (from root), which is ".ghs.yml" and ".ghsignore" in dir over ac of its parent
+func layerAccessConf(ac AccessConf, root, dir string) AccessConf {
+	localDir := filepath.Join(root, dir)
+	warn := func(format string, v ...interface{}) {
+		msg := fmt.Sprintf(format, v...)
+		log.Println(msg)
+		// copied, so confs of sibling directories never share it
+		ac.Warnings = append(ac.Warnings[:len(ac.Warnings):len(ac.Warnings)], msg)
 	}
-	cfgFile := filepath.Join(relPath, ".ghs.yml")
-	data, err := ioutil.ReadFile(cfgFile)
+	// ignore patterns are relative to where they are defined, not inherited like other keys
+	ac.Ignore = nil
+	// warnings are shown to users, with paths from root
+	cfgName := filepath.ToSlash(filepath.Join(dir, ".ghs.yml"))
+	data, err := ioutil.ReadFile(filepath.Join(localDir, ".ghs.yml"))
+	if err != nil && !os.IsNotExist(err) {
+		warn("Err read %s", cfgName)
+	}
+	if len(data) > 0 {
+		// login required by a parent directory could not be unset
+		loginRequired := ac.LoginRequired
+		// a malformed file is ignored as a whole, not applied partly
+		next := cloneAccessConf(ac)
+		if err = yaml.Unmarshal(data, &next); err != nil {
+			warn("Err format %s, ignored: %v", cfgName, err)
+		} else {
+			ac = next
+		}
+		ac.LoginRequired = ac.LoginRequired || loginRequired
+		for _, table := range ac.AccessTables {
+			if _, err := regexp.Compile(table.Regex); err != nil {
+				warn("Err regex of accessTables in %s: %v", cfgName, err)
+			}
+		}
+	}
+
+	patterns := ac.Ignore
+	ignoreName := filepath.ToSlash(filepath.Join(dir, ignoreFileName))
+	filePatterns, err := readIgnoreFile(localDir)
 	if err != nil {
-		if os.IsNotExist(err) {
+		warn("Err read %s", ignoreName)
+	}
+	patterns = append(patterns[:len(patterns):len(patterns)], filePatterns...)
+	if len(patterns) == 0 {
+		return ac
+	}
+	rules := &ignoreRules{root: root, dir: filepath.Clean(dir)}
+	for i, line := range patterns {
+		rule, ok, err := parseIgnorePattern(line)
+		if err != nil {
+			warn("Err pattern %q in ignore of %s: %v", line, filepath.ToSlash(dir), err)
+		}
+		if ok {
+			rule.source = ignoreName
+			if i < len(ac.Ignore) {
+				rule.source = cfgName
+			}
+			rules.rules = append(rules.rules, rule)
+		}
+	}
+	ac.ignores = append(ac.ignores[:len(ac.ignores):len(ac.ignores)], rules)
+	return ac
+}
+
+// accessible reports whether path and all its parents are allowed by accessTables of their directories.
+// Paths not accessible are treated as not existing.
+func (s *HTTPStaticServer) accessible(path string) bool {
+	decision, _ := s.accessibleDecision(path)
+	return decision.Allowed
+}
+
+// accessibleDecision returns the rule blocking path and the directory whose conf defines it,
+// or the rule of path itself if nothing blocks it
+func (s *HTTPStaticServer) accessibleDecision(path string) (decision accessDecision, dir string) {
+	path = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "/")
+	if path == "." || path == "" {
+		return accessDecision{Allowed: true}, "."
+	}
+	parts := strings.Split(path, "/")
+	for i := range parts {
+		dir = filepath.Dir(strings.Join(parts[:i+1], "/"))
+		ac, _ := s.dirAccessConf(dir)
+		if decision = ac.accessDecision(strings.Join(parts[:i+1], "/")); !decision.Allowed {
 			return
 		}
-		log.Printf("Err read .ghs.yml: %v", err)
 	}
-	err = yaml.Unmarshal(data, &ac)
+	return
+}
+
+// checkRead reports whether the user of request could read path, otherwise it responds.
+// Anonymous users are asked to log in, by redirecting browsers to the login page with openid auth, or 401.
+// Logged in users get 403.
+func (s *HTTPStaticServer) checkRead(w http.ResponseWriter, r *http.Request, path string) bool {
+	if !s.accessible(path) {
+		http.NotFound(w, r)
+		return false
+	}
+	auth := s.readAccessConf(path)
+	if auth.canRead(r) {
+		return true
+	}
+	if currentUser(r) == nil && s.AuthType != "http" {
+		if s.AuthType == "openid" && r.Method == "GET" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			http.Redirect(w, r, "/-/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return false
+		}
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return false
+	}
+	http.Error(w, "Read forbidden", http.StatusForbidden)
+	return false
+}
+
+// updateAccessConf rewrites keys of the ".ghs.yml" in directory dir, other keys and comments are kept as is.
+// A nil value removes the key.
+func updateAccessConf(dir string, values map[string]interface{}) error {
+	cfgFile := filepath.Join(dir, ".ghs.yml")
+	var conf yaml.MapSlice
+	data, err := ioutil.ReadFile(cfgFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = yaml.Unmarshal(data, &conf); err != nil {
+		return err
+	}
+	for key, value := range values {
+		idx := -1
+		for i, item := range conf {
+			if item.Key == key {
+				idx = i
+				break
+			}
+		}
+		switch {
+		case value == nil && idx >= 0:
+			conf = append(conf[:idx], conf[idx+1:]...)
+		case value == nil:
+		case idx >= 0:
+			conf[idx].Value = value
+		default:
+			conf = append(conf, yaml.MapItem{Key: key, Value: value})
+		}
+	}
+	if edited, ok := editYAMLKeys(data, values, conf); ok {
+		return writeAccessConf(dir, edited)
+	}
+	// the file is not laid out in lines of keys, so it is marshalled again and comments are lost
+	if data, err = yaml.Marshal(conf); err != nil {
+		return err
+	}
+	return writeAccessConf(dir, data)
+}
+
+// editYAMLKeys replaces the lines of top level keys in yaml data, appends missing ones and removes keys with nil value.
+// It fails unless the result decodes the same as expect.
+func editYAMLKeys(data []byte, values map[string]interface{}, expect yaml.MapSlice) ([]byte, bool) {
+	lines := strings.Split(strings.TrimPrefix(string(data), "---\n"), "\n")
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var repl []string
+		if values[key] != nil {
+			out, err := yaml.Marshal(yaml.MapSlice{{Key: key, Value: values[key]}})
+			if err != nil {
+				return nil, false
+			}
+			repl = strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+		}
+		start, end := -1, len(lines)
+		if end > 0 && lines[end-1] == "" {
+			end--
+		}
+		for i, line := range lines {
+			if strings.HasPrefix(line, key+":") || strings.HasPrefix(line, `"`+key+`":`) || strings.HasPrefix(line, "'"+key+"':") {
+				start, end = i, i+1
+				break
+			}
+		}
+		if start >= 0 {
+			// indented lines and sequence items belong to the value
+			for end < len(lines) && (strings.HasPrefix(lines[end], " ") || strings.HasPrefix(lines[end], "\t") || strings.HasPrefix(lines[end], "- ")) {
+				end++
+			}
+		} else {
+			start = end
+		}
+		lines = append(lines[:start:start], append(repl, lines[end:]...)...)
+	}
+	edited := strings.Join(lines, "\n")
+	if !strings.HasSuffix(edited, "\n") {
+		edited += "\n"
+	}
+	var got, want map[string]interface{}
+	marshalled, err := yaml.Marshal(expect)
+	if err != nil || yaml.Unmarshal([]byte(edited), &got) != nil || yaml.Unmarshal(marshalled, &want) != nil {
+		return nil, false
+	}
+	return []byte(edited), reflect.DeepEqual(got, want)
+}
+
+// writeAccessConf replaces the ".ghs.yml" in directory dir with data
+func writeAccessConf(dir string, data []byte) error {
+	cfgFile := filepath.Join(dir, ".ghs.yml")
+	// write to a temp file first, so readers never see a half written config
+	tmpFile, err := ioutil.TempFile(dir, ".ghs.yml.")
 	if err != nil {
-		log.Printf("Err format .ghs.yml: %v", err)
+		return err
 	}
-	return
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(append([]byte("---\n"), data...))
+	if er := tmpFile.Close(); err == nil {
+		err = er
+	}
+	if err != nil {
+		return err
+	}
+	os.Chmod(tmpFile.Name(), 0644)
+	defer invalidateAccessConf()
+	return os.Rename(tmpFile.Name(), cfgFile)
 }
 
 func deepPath(basedir, name string) string {
@@ -798,6 +1444,9 @@ func deepPath(basedir, name string) string {
 	return name
 }
 
+// internalDir under root keeps server data like unfinished uploads, it is hidden from clients
+const internalDir = ".ghs-data"
+
 func isFile(path string) bool {
 	info, err := os.Stat(path)
 	return err == nil && info.Mode().IsRegular()
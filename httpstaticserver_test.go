@@ -0,0 +1,294 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-yaml/yaml"
+)
+
+func TestAccessConfInGroup(t *testing.T) {
+	conf := AccessConf{
+		groupMembers: map[string][]string{
+			"mobile-team": {"a@x.com", "B@x.com"},
+			"staff":       {"*@ourcorp.com"},
+		},
+	}
+	tests := []struct {
+		user  UserInfo
+		group string
+		pass  bool
+	}{
+		{UserInfo{Email: "a@x.com"}, "mobile-team", true},
+		{UserInfo{Email: "b@X.com"}, "mobile-team", true},
+		{UserInfo{Email: "c@x.com"}, "mobile-team", false},
+		{UserInfo{Email: "c@ourcorp.com"}, "staff", true},
+		{UserInfo{Email: "c@notourcorp.com"}, "staff", false},
+		{UserInfo{Email: "c@x.com", Groups: []string{"staff"}}, "staff", true},
+		{UserInfo{Email: "a@x.com"}, "staff", false},
+		{UserInfo{}, "staff", false},
+	}
+	for _, v := range tests {
+		if res := conf.inGroup(&v.user, v.group); res != v.pass {
+			t.Fatalf("Failed: %v - res:%v", v, res)
+		}
+	}
+}
+
+func TestAccessConfCanAccess(t *testing.T) {
+	conf := AccessConf{
+		AccessTables: []AccessTable{
+			{Regex: `\.secret$`, Allow: false},
+			{Regex: `^docs/internal/`, Allow: false, Match: "path"},
+			{Regex: `^internal$`, Allow: true},
+		},
+	}
+	tests := []struct {
+		path string
+		pass bool
+	}{
+		{"a.txt", true},
+		{"docs/a.secret", false},
+		{"/docs/internal/a.txt", false},
+		{"docs/internal", true},
+		{"other/internal/a.txt", true},
+	}
+	for _, v := range tests {
+		if res := conf.canAccess(v.path); res != v.pass {
+			t.Fatalf("Failed: %v - res:%v", v, res)
+		}
+	}
+}
+
+func TestAccessConfDecision(t *testing.T) {
+	deny := false
+	conf := AccessConf{
+		Upload:        true,
+		LoginRequired: true,
+		Users:         []UserControl{{Email: "a@x.com", Upload: false, Delete: true}},
+		Groups:        []GroupControl{{Name: "staff", Read: &deny}, {Name: "ops", MKDir: true}},
+		groupMembers:  map[string][]string{"staff": {"*@x.com"}, "ops": {"b@x.com"}},
+	}
+	tests := []struct {
+		user   *UserInfo
+		action string
+		pass   bool
+		rule   string
+	}{
+		{nil, actionRead, false, "loginRequired"},
+		{nil, actionUpload, true, "default"},
+		{&UserInfo{Email: "a@x.com"}, actionUpload, false, "user"},
+		{&UserInfo{Email: "a@x.com"}, actionRead, false, "group"},
+		{&UserInfo{Email: "b@x.com"}, actionMKDir, true, "group"},
+		{&UserInfo{Email: "c@y.com"}, actionRead, true, "default"},
+	}
+	for _, v := range tests {
+		var res accessDecision
+		if v.action == actionRead {
+			res = conf.readDecision(v.user)
+		} else {
+			res = conf.decision(v.user, v.action)
+		}
+		if res.Allowed != v.pass || res.Rule != v.rule {
+			t.Fatalf("Failed: %v - res:%v", v, res)
+		}
+	}
+}
+
+func TestEditPut(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "dir"), 0755)
+	os.MkdirAll(filepath.Join(root, "keep"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "keep", ".ghs.yml"), []byte("upload: true\nonConflict: reject\n"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "keep", "a.bin"), []byte("old"), 0644)
+	s := NewHTTPStaticServer(root)
+	binary := "\x00\xff\r\n\x1a" + strings.Repeat("x", 1<<16)
+
+	tests := []struct {
+		upload  bool
+		url     string
+		body    string
+		sha256  string
+		code    int
+		content string // of url after request
+	}{
+		{false, "/a.bin", binary, "", http.StatusForbidden, ""},
+		{true, "/a.bin", binary, "", http.StatusCreated, binary},
+		{true, "/a.bin", "new", "", http.StatusNoContent, "new"},
+		{true, "/a.bin", "bad", "0000000000000000000000000000000000000000000000000000000000000000", http.StatusBadRequest, "new"},
+		{true, "/dir", "x", "", http.StatusForbidden, ""},
+		{true, "/nodir/a.bin", "x", "", http.StatusConflict, ""},
+		{true, "/keep/a.bin", "new", "", http.StatusConflict, "old"},
+	}
+	for _, v := range tests {
+		s.Upload = v.upload
+		invalidateAccessConf()
+		req := httptest.NewRequest("PUT", v.url, strings.NewReader(v.body))
+		if v.sha256 != "" {
+			req.Header.Set("X-Checksum-Sha256", v.sha256)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != v.code {
+			t.Fatalf("Failed: %s %.8q - code:%d %s", v.url, v.body, w.Code, w.Body.String())
+		}
+		if (w.Code == http.StatusCreated || w.Code == http.StatusNoContent) && w.Header().Get("ETag") == "" {
+			t.Fatalf("Failed: %s - no etag", v.url)
+		}
+		if w.Code == http.StatusNoContent && w.Body.Len() != 0 {
+			t.Fatalf("Failed: %s - body:%s", v.url, w.Body.String())
+		}
+		if v.content == "" {
+			continue
+		}
+		if data, _ := ioutil.ReadFile(filepath.Join(root, v.url)); string(data) != v.content {
+			t.Fatalf("Failed: %s %.8q - content:%.8q", v.url, v.body, data)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(root, "nodir")); err == nil {
+		t.Fatal("Failed: nodir created")
+	}
+}
+
+func TestMkdir(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "ro"), 0755)
+	ioutil.WriteFile(filepath.Join(root, ".ghs.yml"), []byte("mkdir: true\nupload: true\n"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "ro", ".ghs.yml"), []byte("mkdir: false\n"), 0644)
+	s := NewHTTPStaticServer(root)
+	s.ACLTemplates = map[string]yaml.MapSlice{"private": {{Key: "loginRequired", Value: true}}}
+
+	tests := []struct {
+		url         string
+		body        string
+		defaultTmpl string
+		code        int
+		conf        bool // ".ghs.yml" written to the new directory
+	}{
+		{"/-/mkdir/", "folderName=", "", http.StatusBadRequest, false},
+		{"/-/mkdir/", "folderName=a", "", http.StatusOK, false},
+		{"/-/mkdir/", "folderName=a", "", http.StatusConflict, false},
+		{"/-/mkdir/", "folderName=a&parents=true", "", http.StatusOK, false},
+		{"/-/mkdir/a", "folderName=x/y/z", "", http.StatusConflict, false},
+		{"/-/mkdir/a", "folderName=x/y/z&parents=true", "", http.StatusOK, false},
+		{"/-/mkdir/ro", "folderName=b", "", http.StatusForbidden, false},
+		{"/-/mkdir/ro", "folderName=b/c&parents=true", "", http.StatusForbidden, false},
+		{"/-/mkdir/", "folderName=p&template=unknown", "", http.StatusBadRequest, false},
+		{"/-/mkdir/", "folderName=p&template=private", "", http.StatusOK, true},
+		{"/-/mkdir/", "folderName=q", "private", http.StatusOK, true},
+	}
+	for _, v := range tests {
+		s.MkdirTemplate = v.defaultTmpl
+		invalidateAccessConf()
+		req := httptest.NewRequest("POST", v.url, strings.NewReader(v.body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != v.code {
+			t.Fatalf("Failed: %s %s - code:%d %s", v.url, v.body, w.Code, w.Body.String())
+		}
+		form, _ := url.ParseQuery(v.body)
+		folder := filepath.Join(root, strings.TrimPrefix(v.url, "/-/mkdir/"), form.Get("folderName"))
+		if v.code == http.StatusOK && !isDir(folder) {
+			t.Fatalf("Failed: %s %s - not created", v.url, v.body)
+		}
+		if conf := isFile(filepath.Join(folder, ".ghs.yml")); v.code == http.StatusOK && conf != v.conf {
+			t.Fatalf("Failed: %s %s - conf:%v", v.url, v.body, conf)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(root, "ro", "b")); err == nil {
+		t.Fatal("Failed: ro/b created")
+	}
+	// new directories inherit access of their parent, unless a template is given
+	invalidateAccessConf()
+	if auth := s.readAccessConf("a/x/y/z"); !auth.MKDir || !auth.Upload || auth.Delete {
+		t.Fatalf("Failed: a/x/y/z not inherited: %+v", auth)
+	}
+	if auth := s.readAccessConf("q"); !auth.LoginRequired {
+		t.Fatal("Failed: template of mkdir not applied")
+	}
+}
+
+// loginCookie returns the session cookie of user email
+func loginCookie(t *testing.T, email string) string {
+	req := httptest.NewRequest("GET", "/", nil)
+	session, err := store.Get(req, defaultSessionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Values["user"] = &UserInfo{Email: email}
+	w := httptest.NewRecorder()
+	if err = session.Save(req, w); err != nil {
+		t.Fatal(err)
+	}
+	return w.Header().Get("Set-Cookie")
+}
+
+func TestReadPermission(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "private"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "private", ".ghs.yml"), []byte("loginRequired: true\nread: false\nusers:\n- email: a@x.com\n  read: true\n"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "private", "f.txt"), []byte("f"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "pub.txt"), []byte("p"), 0644)
+	s := NewHTTPStaticServer(root)
+	cookies := map[string]string{"": "", "a": loginCookie(t, "a@x.com"), "b": loginCookie(t, "b@x.com")}
+
+	tests := []struct {
+		authType string
+		user     string
+		url      string
+		html     bool
+		code     int
+	}{
+		{"", "", "/pub.txt", false, http.StatusOK},
+		{"", "", "/private/f.txt", false, http.StatusUnauthorized},
+		{"openid", "", "/private/f.txt", false, http.StatusUnauthorized},
+		{"openid", "", "/private/f.txt", true, http.StatusFound},
+		{"openid", "b", "/private/f.txt", true, http.StatusForbidden},
+		{"openid", "a", "/private/f.txt", false, http.StatusOK},
+		{"openid", "", "/-/json/private", false, http.StatusUnauthorized},
+		{"openid", "b", "/-/json/private", false, http.StatusForbidden},
+		{"openid", "a", "/-/json/private", false, http.StatusOK},
+		{"openid", "b", "/-/zip/private", false, http.StatusForbidden},
+		{"openid", "b", "/-/info/private/f.txt", false, http.StatusForbidden},
+		{"openid", "b", "/-/checkout/private", false, http.StatusForbidden},
+		{"openid", "a", "/-/checkout/private", false, http.StatusOK},
+	}
+	for _, v := range tests {
+		s.AuthType = v.authType
+		invalidateAccessConf()
+		req := httptest.NewRequest("GET", v.url, nil)
+		if v.html {
+			req.Header.Set("Accept", "text/html")
+		}
+		if cookie := cookies[v.user]; cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != v.code {
+			t.Fatalf("Failed: %v - code:%d %s", v, w.Code, w.Body.String())
+		}
+		if location := w.Header().Get("Location"); v.code == http.StatusFound && location != "/-/login?next="+url.QueryEscape(v.url) {
+			t.Fatalf("Failed: %v - location:%s", v, location)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+// Visibility rules in gitignore syntax, from "ignore" of ".ghs.yml" and the ".ghsignore" file of directories.
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const ignoreFileName = ".ghsignore"
+
+type ignoreRule struct {
+	pattern *regexp.Regexp // matches entry path relative to the directory
+	negate  bool
+	dirOnly bool
+	line    string // as written
+	source  string // file defining it, from root
+}
+
+// ignoreRules are rules defined in directory dir, which apply to everything below it
+type ignoreRules struct {
+	root  string
+	dir   string // from root
+	rules []ignoreRule
+}
+
+// parseIgnorePattern compiles a gitignore pattern. A pattern without slash matches the name of entries at any level,
+// a leading or middle slash anchors it to the directory, and a trailing slash matches only directories.
+func parseIgnorePattern(line string) (rule ignoreRule, ok bool, err error) {
+	pattern := strings.TrimSpace(line)
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return rule, false, nil
+	}
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	expr := "^"
+	if !strings.Contains(pattern, "/") {
+		expr += "(?:.*/)?"
+	}
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" {
+		return rule, false, filepath.ErrBadPattern
+	}
+	glob, err := globRegexp(pattern)
+	if err != nil {
+		return rule, false, err
+	}
+	if rule.pattern, err = regexp.Compile(expr + glob + "$"); err != nil {
+		return rule, false, err
+	}
+	rule.line = strings.TrimSpace(line)
+	return rule, true, nil
+}
+
+// globRegexp converts glob pattern to regexp. "*" and "?" do not match "/", "**/" matches any directories,
+// and a trailing "/**" everything inside. Other characters match literally.
+func globRegexp(pattern string) (string, error) {
+	var buf bytes.Buffer
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/") && (i == 0 || pattern[i-1] == '/'):
+			buf.WriteString("(?:.*/)?")
+			i += 2
+		case pattern[i:] == "**" && i > 0 && pattern[i-1] == '/':
+			buf.WriteString(".*")
+			i++
+		case c == '*':
+			buf.WriteString("[^/]*")
+		case c == '?':
+			buf.WriteString("[^/]")
+		case c == '\\':
+			if i+1 == len(pattern) {
+				return "", filepath.ErrBadPattern
+			}
+			i++
+			buf.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return "", filepath.ErrBadPattern
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			if class == "" || class == "^" {
+				return "", filepath.ErrBadPattern
+			}
+			buf.WriteString("[" + strings.Replace(class, "[", "\\[", -1) + "]")
+			i += end + 1
+		default:
+			buf.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	return buf.String(), nil
+}
+
+// readIgnoreFile reads patterns of ".ghsignore" in local directory dir, one per line
+func readIgnoreFile(dir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dir, ignoreFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		patterns = append(patterns, scanner.Text())
+	}
+	return patterns, scanner.Err()
+}
+
+// match returns whether entry path (from root) is ignored, ok is false if no rule matches it.
+// The last matching rule wins.
+func (rs *ignoreRules) match(path string) (ignored, ok bool) {
+	if rule := rs.matchRule(path); rule != nil {
+		return !rule.negate, true
+	}
+	return false, false
+}
+
+// matchRule returns the last rule matching entry path (from root), nil if none
+func (rs *ignoreRules) matchRule(path string) (matched *ignoreRule) {
+	rel, err := filepath.Rel(rs.dir, path)
+	rel = filepath.ToSlash(rel)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return nil
+	}
+	isDir := -1
+	for i, rule := range rs.rules {
+		if rule.dirOnly {
+			if isDir < 0 {
+				isDir = 0
+				if info, err := os.Stat(filepath.Join(rs.root, path)); err == nil && info.IsDir() {
+					isDir = 1
+				}
+			}
+			if isDir == 0 {
+				continue
+			}
+		}
+		if rule.pattern.MatchString(rel) {
+			matched = &rs.rules[i]
+		}
+	}
+	return
+}
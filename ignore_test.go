@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIgnoreRulesMatch(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "docs/build"), 0755)
+	os.MkdirAll(filepath.Join(root, "docs/rebuild"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "docs/dist"), nil, 0644)
+
+	rules := &ignoreRules{root: root, dir: "docs"}
+	for _, line := range []string{"# comment", "*.log", "!keep.log", "/private", "build/", "dist/", "a/**/z", "secret", "*.c++", "a(1).txt", "logs/**"} {
+		rule, ok, err := parseIgnorePattern(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			rules.rules = append(rules.rules, rule)
+		}
+	}
+	tests := []struct {
+		path    string
+		ignored bool
+	}{
+		{"docs/x.log", true},
+		{"docs/sub/x.log", true},
+		{"docs/sub/keep.log", false},
+		{"docs/private", true},
+		{"docs/sub/private", false},
+		{"docs/build", true},
+		{"docs/rebuild", false},
+		{"docs/sub/secret", true},
+		{"docs/topsecret", false},
+		{"docs/secrets", false},
+		{"docs/main.c++", true},
+		{"docs/main.cc", false},
+		{"docs/a(1).txt", true},
+		{"docs/a1.txt", false},
+		{"docs/logs/x/y", true},
+		{"docs/logs", false},
+		{"docs/dist", false},
+		{"docs/a/b/c/z", true},
+		{"x.log", false},
+		{"docs", false},
+	}
+	for _, v := range tests {
+		if ignored, _ := rules.match(v.path); ignored != v.ignored {
+			t.Fatalf("Failed: %v - res:%v", v, ignored)
+		}
+	}
+	for _, line := range []string{"[a-", "[z-a]", "/"} {
+		if _, _, err := parseIgnorePattern(line); err == nil {
+			t.Fatalf("invalid pattern %q should be reported", line)
+		}
+	}
+
+	// rules only apply below their directory
+	rule, _, _ := parseIgnorePattern("*secret")
+	rules = &ignoreRules{root: root, dir: ".", rules: []ignoreRule{rule}}
+	if ignored, _ := rules.match("..secret"); !ignored {
+		t.Fatal("Failed: ..secret is below root")
+	}
+	rules.dir = "docs"
+	if ignored, _ := rules.match("..secret"); ignored {
+		t.Fatal("Failed: ..secret is not below docs")
+	}
+}
+
+func TestIgnoredPathNotFound(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "secret"), 0755)
+	os.MkdirAll(filepath.Join(root, "ch"), 0755)
+	for _, name := range []string{"secret/a.apk", "ch/a.apk", "ch/hidden.apk"} {
+		ioutil.WriteFile(filepath.Join(root, name), []byte(name), 0644)
+	}
+	ioutil.WriteFile(filepath.Join(root, ignoreFileName), []byte("secret/\nhidden.apk\n"), 0644)
+	s := NewHTTPStaticServer(root)
+	s.Upload = true
+
+	tests := []struct {
+		url  string
+		body string
+		code int
+	}{
+		{"/-/checkout/secret", "file=a.apk", http.StatusNotFound},
+		{"/-/rollback/secret", "", http.StatusNotFound},
+		{"/-/rollout/secret", "action=start&file=a.apk&percent=10", http.StatusNotFound},
+		{"/-/extract/secret?format=tar", "", http.StatusNotFound},
+		{"/-/checkout/ch", "file=hidden.apk", http.StatusBadRequest},
+		{"/-/rollout/ch", "action=start&file=hidden.apk&percent=10", http.StatusBadRequest},
+		{"/-/checkout/ch", "file=a.apk", http.StatusOK},
+	}
+	for _, v := range tests {
+		req := httptest.NewRequest("POST", v.url, strings.NewReader(v.body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != v.code {
+			t.Fatalf("Failed: %v - code:%d %s", v, w.Code, w.Body.String())
+		}
+	}
+}
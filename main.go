@@ -13,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/alecthomas/kingpin"
 	"github.com/go-yaml/yaml"
@@ -22,26 +23,35 @@ import (
 )
 
 type Configure struct {
-	Conf            *os.File `yaml:"-"`
-	Addr            string   `yaml:"addr"`
-	Root            string   `yaml:"root"`
-	HTTPAuth        string   `yaml:"httpauth"`
-	Cert            string   `yaml:"cert"`
-	Key             string   `yaml:"key"`
-	Cors            bool     `yaml:"cors"`
-	Theme           string   `yaml:"theme"`
-	XHeaders        bool     `yaml:"xheaders"`
-	Upload          bool     `yaml:"upload"`
-	Delete          bool     `yaml:"delete"`
-	MKDir           bool     `yaml:"mkdir"`
-	PlistProxy      string   `yaml:"plistproxy"`
-	Title           string   `yaml:"title"`
-	Debug           bool     `yaml:"debug"`
-	GoogleTrackerId string   `yaml:"google-tracker-id"`
+	Conf            *os.File                 `yaml:"-"`
+	Addr            string                   `yaml:"addr"`
+	Root            string                   `yaml:"root"`
+	HTTPAuth        string                   `yaml:"httpauth"`
+	Cert            string                   `yaml:"cert"`
+	Key             string                   `yaml:"key"`
+	Cors            bool                     `yaml:"cors"`
+	Theme           string                   `yaml:"theme"`
+	XHeaders        bool                     `yaml:"xheaders"`
+	Upload          bool                     `yaml:"upload"`
+	Delete          bool                     `yaml:"delete"`
+	MKDir           bool                     `yaml:"mkdir"`
+	PlistProxy      string                   `yaml:"plistproxy"`
+	Title           string                   `yaml:"title"`
+	Debug           bool                     `yaml:"debug"`
+	GoogleTrackerId string                   `yaml:"google-tracker-id"`
+	UploadExpire    time.Duration            `yaml:"upload-expire"`
+	ExtractMaxSize  int64                    `yaml:"extract-max-size"`
+	ExtractMaxFiles int                      `yaml:"extract-max-files"`
+	TrashDays       int                      `yaml:"trash-days"`
+	ACLTemplates    map[string]yaml.MapSlice `yaml:"acl-templates"`
+	MkdirTemplate   string                   `yaml:"mkdir-template"`
+	Groups          map[string][]string      `yaml:"groups"`
+	Admins          []string                 `yaml:"admins"`
 	Auth            struct {
-		Type   string `yaml:"type"`
-		OpenID string `yaml:"openid"`
-		HTTP   string `yaml:"http"`
+		Type        string `yaml:"type"`
+		OpenID      string `yaml:"openid"`
+		HTTP        string `yaml:"http"`
+		GroupsClaim string `yaml:"groups-claim"`
 	} `yaml:"auth"`
 }
 
@@ -92,6 +102,10 @@ func parseFlags() error {
 	gcfg.Auth.OpenID = defaultOpenID
 	gcfg.GoogleTrackerId = "UA-81205425-2"
 	gcfg.Title = "Go HTTP File Server"
+	gcfg.UploadExpire = 24 * time.Hour
+	gcfg.ExtractMaxSize = 1 << 30
+	gcfg.ExtractMaxFiles = 10000
+	gcfg.TrashDays = 30
 
 	kingpin.HelpFlag.Short('h')
 	kingpin.Version(versionMessage())
@@ -103,6 +117,7 @@ func parseFlags() error {
 	kingpin.Flag("auth-type", "Auth type <http|openid>").StringVar(&gcfg.Auth.Type)
 	kingpin.Flag("auth-http", "HTTP basic auth (ex: user:pass)").StringVar(&gcfg.Auth.HTTP)
 	kingpin.Flag("auth-openid", "OpenID auth identity url").StringVar(&gcfg.Auth.OpenID)
+	kingpin.Flag("auth-groups-claim", "OpenID response field of user groups, e.g. openid.ax.value.groups").StringVar(&gcfg.Auth.GroupsClaim)
 	kingpin.Flag("theme", "web theme, one of <black|green>").StringVar(&gcfg.Theme)
 	kingpin.Flag("upload", "enable upload support").BoolVar(&gcfg.Upload)
 	kingpin.Flag("delete", "enable delete support").BoolVar(&gcfg.Delete)
@@ -113,6 +128,11 @@ func parseFlags() error {
 	kingpin.Flag("plistproxy", "plist proxy when server is not https").Short('p').StringVar(&gcfg.PlistProxy)
 	kingpin.Flag("title", "server title").StringVar(&gcfg.Title)
 	kingpin.Flag("google-tracker-id", "set to empty to disable it").StringVar(&gcfg.GoogleTrackerId)
+	kingpin.Flag("upload-expire", "unfinished resumable uploads expire after, default 24h").DurationVar(&gcfg.UploadExpire)
+	kingpin.Flag("extract-max-size", "max bytes extracted from an uploaded archive, default 1G").Int64Var(&gcfg.ExtractMaxSize)
+	kingpin.Flag("extract-max-files", "max files extracted from an uploaded archive, default 10000").IntVar(&gcfg.ExtractMaxFiles)
+	kingpin.Flag("mkdir-template", "ACL template of acl-templates in config written to new directories, default inherit").StringVar(&gcfg.MkdirTemplate)
+	kingpin.Flag("trash-days", "days deleted files are kept in trash, 0 deletes immediately, default 30").IntVar(&gcfg.TrashDays)
 
 	kingpin.Parse() // first parse conf
 
@@ -145,7 +165,37 @@ func main() {
 	ss.GoogleTrackerId = gcfg.GoogleTrackerId
 	ss.Upload = gcfg.Upload
 	ss.Delete = gcfg.Delete
+	ss.MKDir = gcfg.MKDir
 	ss.AuthType = gcfg.Auth.Type
+	ss.UploadExpire = gcfg.UploadExpire
+	ss.ExtractMaxSize = gcfg.ExtractMaxSize
+	ss.ExtractMaxFiles = gcfg.ExtractMaxFiles
+	ss.TrashDays = gcfg.TrashDays
+	ss.ACLTemplates = gcfg.ACLTemplates
+	ss.MkdirTemplate = gcfg.MkdirTemplate
+	for name, conf := range gcfg.ACLTemplates {
+		data, _ := yaml.Marshal(conf)
+		if err := yaml.Unmarshal(data, &AccessConf{}); err != nil {
+			log.Fatalf("invalid acl template %s: %v", name, err)
+		}
+	}
+	if _, ok := gcfg.ACLTemplates[gcfg.MkdirTemplate]; gcfg.MkdirTemplate != "" && !ok {
+		log.Fatalf("mkdir template %s not in acl-templates", gcfg.MkdirTemplate)
+	}
+	for name, members := range gcfg.Groups {
+		for _, member := range members {
+			if !validMember(member) {
+				log.Fatalf("invalid member of group %s: %s, should be an email or *@domain", name, member)
+			}
+		}
+	}
+	ss.Groups = gcfg.Groups
+	for _, admin := range gcfg.Admins {
+		if !validMember(admin) {
+			log.Fatalf("invalid admin %s, should be an email or *@domain", admin)
+		}
+	}
+	ss.Admins = gcfg.Admins
 
 	if gcfg.PlistProxy != "" {
 		u, err := url.Parse(gcfg.PlistProxy)
@@ -169,7 +219,7 @@ func main() {
 			hdlr = httpauth.SimpleBasicAuth(user, pass)(hdlr)
 		}
 	case "openid":
-		handleOpenID(false) // FIXME(ssx): set secure default to false
+		handleOpenID(false, gcfg.Auth.GroupsClaim) // FIXME(ssx): set secure default to false
 	}
 	// CORS
 	if gcfg.Cors {
@@ -20,10 +20,11 @@ var (
 )
 
 type UserInfo struct {
-	Id       string `json:"id"`
-	Email    string `json:"email"`
-	Name     string `json:"name"`
-	NickName string `json:"nickName"`
+	Id       string   `json:"id"`
+	Email    string   `json:"email"`
+	Name     string   `json:"name"`
+	NickName string   `json:"nickName"`
+	Groups   []string `json:"groups,omitempty"` // given by identity provider
 }
 
 type M map[string]interface{}
@@ -33,7 +34,29 @@ func init() {
 	gob.Register(&M{})
 }
 
-func handleOpenID(secure bool) {
+// currentUser returns the logged in user, nil for anonymous
+func currentUser(r *http.Request) *UserInfo {
+	session, err := store.Get(r, defaultSessionName)
+	if err != nil {
+		return nil
+	}
+	userInfo, _ := session.Values["user"].(*UserInfo)
+	return userInfo
+}
+
+// signedValue returns value of OpenID response field key, only if it is signed by the provider
+func signedValue(r *http.Request, key string) string {
+	for _, name := range strings.Split(r.FormValue("openid.signed"), ",") {
+		if "openid."+name == key {
+			return r.FormValue(key)
+		}
+	}
+	return ""
+}
+
+// handleOpenID registers login handlers, groups of user are read from the response field groupsClaim
+// (e.g. "openid.ax.value.groups") as a comma separated list
+func handleOpenID(secure bool, groupsClaim string) {
 	http.HandleFunc("/-/login", func(w http.ResponseWriter, r *http.Request) {
 		nextUrl := r.FormValue("next")
 		referer := r.Referer()
@@ -69,6 +92,13 @@ func handleOpenID(secure bool) {
 			Name:     r.FormValue("openid.sreg.fullname"),
 			NickName: r.FormValue("openid.sreg.nickname"),
 		}
+		if groupsClaim != "" {
+			for _, group := range strings.Split(signedValue(r, groupsClaim), ",") {
+				if group = strings.TrimSpace(group); group != "" {
+					user.Groups = append(user.Groups, group)
+				}
+			}
+		}
 		session.Values["user"] = user
 		if err := session.Save(r, w); err != nil {
 			log.Println("session save error:", err)
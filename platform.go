@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// aliases of operating systems and architectures, the first one is the canonical (GOOS/GOARCH) name
+var (
+	osAliases = [][]string{
+		{"windows", "win", "win32", "win64"},
+		{"darwin", "macos", "macosx", "osx", "mac"},
+		{"android"},
+		{"linux"},
+		{"freebsd"},
+		{"openbsd"},
+		{"netbsd"},
+	}
+	archAliases = [][]string{
+		{"amd64", "x86_64", "x86-64", "x64"},
+		{"arm64", "aarch64", "armv8"},
+		{"arm", "armv7", "armv7l", "armv6", "armv6l", "armhf"},
+		{"386", "i386", "i686", "x86"},
+		{"ppc64le"},
+		{"s390x"},
+		{"mips"},
+		{"mipsle"},
+	}
+)
+
+type platform struct {
+	OS   string
+	Arch string
+}
+
+// lookupAliases returns all aliases of name, name itself is returned if not known
+func lookupAliases(table [][]string, name string) []string {
+	name = strings.ToLower(name)
+	for _, aliases := range table {
+		for _, alias := range aliases {
+			if alias == name {
+				return aliases
+			}
+		}
+	}
+	return []string{name}
+}
+
+// userAgentPlatform guesses platform from user agent, fields which could not be detected are left empty.
+func userAgentPlatform(ua string) (p platform) {
+	ua = strings.ToLower(ua)
+	switch {
+	case strings.Contains(ua, "windows"):
+		p.OS = "windows"
+	case strings.Contains(ua, "android"): // android user agent contains linux too
+		p.OS = "android"
+	case strings.Contains(ua, "mac os x") || strings.Contains(ua, "macintosh") || strings.Contains(ua, "darwin"):
+		p.OS = "darwin"
+	case strings.Contains(ua, "linux"):
+		p.OS = "linux"
+	case strings.Contains(ua, "freebsd"):
+		p.OS = "freebsd"
+	}
+	switch {
+	case strings.Contains(ua, "x86_64") || strings.Contains(ua, "amd64") ||
+		strings.Contains(ua, "win64") || strings.Contains(ua, "wow64") || strings.Contains(ua, "x64"):
+		p.Arch = "amd64"
+	case strings.Contains(ua, "aarch64") || strings.Contains(ua, "arm64"):
+		p.Arch = "arm64"
+	case strings.Contains(ua, "armv7") || strings.Contains(ua, "armv6"):
+		p.Arch = "arm"
+	case strings.Contains(ua, "i686") || strings.Contains(ua, "i386"):
+		p.Arch = "386"
+	}
+	return
+}
+
+// requestPlatform reads platform from "os" and "arch" query, or from User-Agent when both are absent
+func requestPlatform(req *http.Request) (p platform, fromUA bool) {
+	p.OS, p.Arch = req.FormValue("os"), req.FormValue("arch")
+	if p.OS == "" && p.Arch == "" {
+		return userAgentPlatform(req.UserAgent()), true
+	}
+	return p, false
+}
+
+// parsePlatform parses platform like "linux/amd64", arch could be omitted
+func parsePlatform(s string) (p platform) {
+	parts := strings.SplitN(strings.ToLower(strings.TrimSpace(s)), "/", 2)
+	p.OS = parts[0]
+	if len(parts) == 2 {
+		p.Arch = parts[1]
+	}
+	return
+}
+
+func aliasesRegexp(aliases []string) string {
+	sorted := append([]string(nil), aliases...)
+	// longer aliases first, so "x86_64" is not matched as "x86"
+	sort.Sort(sort.Reverse(byLength(sorted)))
+	for i, alias := range sorted {
+		sorted[i] = regexp.QuoteMeta(alias)
+	}
+	return "(" + strings.Join(sorted, "|") + ")"
+}
+
+type byLength []string
+
+func (a byLength) Len() int           { return len(a) }
+func (a byLength) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byLength) Less(i, j int) bool { return len(a[i]) < len(a[j]) }
+
+// platformPattern compiles file name pattern like "foo-{version}-{os}-{arch}.tar.gz" for platform p.
+// "*" and "?" are wildcards as in shell, placeholders of empty platform fields match anything.
+func platformPattern(pattern string, p platform) (*regexp.Regexp, error) {
+	placeholders := map[string]string{
+		"{os}":      `(?P<os>[0-9a-z]+)`,
+		"{arch}":    `(?P<arch>[0-9a-z_]+)`,
+		"{version}": `(?P<version>[vV]?\d+(?:\.\d+){0,2}(?:-[0-9A-Za-z][0-9A-Za-z.-]*)?)`,
+	}
+	if p.OS != "" {
+		placeholders["{os}"] = `(?P<os>` + aliasesRegexp(lookupAliases(osAliases, p.OS)) + `)`
+	}
+	if p.Arch != "" {
+		placeholders["{arch}"] = `(?P<arch>` + aliasesRegexp(lookupAliases(archAliases, p.Arch)) + `)`
+	}
+	expr := ""
+	for len(pattern) > 0 {
+		matched := false
+		for placeholder, re := range placeholders {
+			if strings.HasPrefix(pattern, placeholder) {
+				expr += re
+				pattern = pattern[len(placeholder):]
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		switch pattern[0] {
+		case '*':
+			expr += ".*"
+		case '?':
+			expr += "."
+		default:
+			expr += regexp.QuoteMeta(pattern[:1])
+		}
+		pattern = pattern[1:]
+	}
+	return regexp.Compile("(?i)^" + expr + "$")
+}
+
+// platformVersion extracts version of a file name matched by platformPattern
+func platformVersion(re *regexp.Regexp, name string) *Version {
+	loc := re.FindStringSubmatchIndex(name)
+	if loc == nil {
+		return nil
+	}
+	stripped := ""
+	last := 0
+	for i, group := range re.SubexpNames() {
+		if loc[2*i] < 0 {
+			continue
+		}
+		switch group {
+		case "version":
+			v, err := parseVersion(name[loc[2*i]:loc[2*i+1]])
+			if err != nil {
+				return nil
+			}
+			return v
+		case "os", "arch":
+			// os and arch names should not be parsed as part of version
+			if loc[2*i] >= last {
+				stripped += name[last:loc[2*i]]
+				last = loc[2*i+1]
+			}
+		}
+	}
+	return versionFromFileName(stripped + name[last:])
+}
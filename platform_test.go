@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlatformPattern(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		platform platform
+		name     string
+		pass     bool
+		version  string
+	}{
+		{"foo-{os}-{arch}.tar.gz", platform{"linux", "amd64"}, "foo-linux-amd64.tar.gz", true, ""},
+		{"foo-{os}-{arch}.tar.gz", platform{"Linux", "x86_64"}, "foo-linux-amd64.tar.gz", true, ""},
+		{"foo-{os}-{arch}.tar.gz", platform{"linux", "arm64"}, "foo-linux-amd64.tar.gz", false, ""},
+		{"foo-{os}-{arch}.tar.gz", platform{"darwin", ""}, "foo-macos-arm64.tar.gz", true, ""},
+		{"foo_{os}_{arch}*", platform{"linux", "amd64"}, "foo_linux_x86_64.zip", true, ""},
+		{"foo_{os}_{arch}.zip", platform{"linux", "386"}, "foo_linux_x86_64.zip", false, ""},
+		{"foo-*-{os}-{arch}.tar.gz", platform{"linux", "amd64"}, "foo-1.4.2-linux-amd64.tar.gz", true, "1.4.2"},
+		{"foo-{version}-{os}-{arch}.tar.gz", platform{"windows", "amd64"}, "foo-v2.0.1-win64-x64.tar.gz", true, "2.0.1"},
+	}
+	for _, v := range tests {
+		re, err := platformPattern(v.pattern, v.platform)
+		if err != nil {
+			t.Fatalf("Failed: %v - err:%v", v, err)
+		}
+		if res := re.MatchString(v.name); res != v.pass {
+			t.Fatalf("Failed: %v - res:%v", v, res)
+		}
+		if v.version == "" {
+			continue
+		}
+		if ver := platformVersion(re, v.name); ver == nil || ver.String() != v.version {
+			t.Fatalf("Failed: %v - version:%v", v, ver)
+		}
+	}
+}
+
+func TestUserAgentPlatform(t *testing.T) {
+	tests := []struct {
+		ua       string
+		platform platform
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36", platform{"windows", "amd64"}},
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36", platform{"linux", "amd64"}},
+		{"Mozilla/5.0 (X11; Linux aarch64; rv:91.0) Gecko/20100101", platform{"linux", "arm64"}},
+		{"Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36", platform{"android", ""}},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7)", platform{"darwin", ""}},
+		{"curl/7.68.0", platform{}},
+	}
+	for _, v := range tests {
+		if res := userAgentPlatform(v.ua); res != v.platform {
+			t.Fatalf("Failed: %v - res:%v", v, res)
+		}
+	}
+}
+
+func TestCheckoutPlatformFallback(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, name := range []string{"foo-linux-amd64.tar.gz", "foo-darwin-arm64.tar.gz"} {
+		ioutil.WriteFile(filepath.Join(root, name), []byte(name), 0644)
+	}
+	s := &HTTPStaticServer{Root: root}
+	tests := []struct {
+		url  string
+		conf AccessConf
+		name string
+	}{
+		{"/?os=darwin&arch=arm64", AccessConf{}, "foo-darwin-arm64.tar.gz"},
+		{"/", AccessConf{DefaultPlatform: "linux/amd64"}, "foo-linux-amd64.tar.gz"},
+		{"/", AccessConf{Checked: "foo-darwin-arm64.tar.gz"}, "foo-darwin-arm64.tar.gz"},
+		{"/", AccessConf{Checked: "foo-linux-amd64.tar.gz", DefaultPlatform: "darwin"}, "foo-darwin-arm64.tar.gz"},
+	}
+	for _, v := range tests {
+		req := httptest.NewRequest("GET", v.url, nil)
+		req.Header.Set("User-Agent", "curl/7.68.0")
+		v.conf.Platform = "foo-{os}-{arch}.tar.gz"
+		name, _, err := s.resolveCheckout(req, ".", v.conf)
+		if err != nil || name != v.name {
+			t.Fatalf("Failed: %v - name:%v err:%v", v, name, err)
+		}
+	}
+}
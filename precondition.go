@@ -0,0 +1,153 @@
+package main
+
+// Conditional requests (RFC 7232) for writes, so concurrent edits are not lost silently.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileETag returns the strong etag of regular file path, made of inode, mtime and size.
+// The server replaces files by rename, so every write gets a new inode even within the mtime granularity.
+func fileETag(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a file", path)
+	}
+	return fmt.Sprintf(`"%x-%x-%x"`, fileInode(info), info.ModTime().UnixNano(), info.Size()), nil
+}
+
+// setETag sets the etag header of file path, if it is a regular file
+func setETag(w http.ResponseWriter, path string) {
+	if etag, err := fileETag(path); err == nil {
+		w.Header().Set("ETag", etag)
+	}
+}
+
+// FileVersion describes the current version of a file when precondition fails
+type FileVersion struct {
+	ETag    string `json:"etag"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"` // milliseconds
+}
+
+func statFileVersion(path string) *FileVersion {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	etag, _ := fileETag(path)
+	return &FileVersion{
+		ETag:    etag,
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano() / 1e6,
+	}
+}
+
+type preconditionError struct {
+	Message string
+	Current *FileVersion // nil if file not exists
+}
+
+func (e *preconditionError) Error() string {
+	return e.Message
+}
+
+// writePreconditionFailed responds 412 with the current version of file
+func writePreconditionFailed(w http.ResponseWriter, e *preconditionError) {
+	if e.Current != nil {
+		w.Header().Set("ETag", e.Current.ETag)
+	}
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.WriteHeader(http.StatusPreconditionFailed)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"error":   e.Message,
+		"current": e.Current,
+	})
+}
+
+// fileWriteMu makes checking preconditions and replacing a file one step among writes of the server
+var fileWriteMu sync.Mutex
+
+// headerValue returns the first non empty value of key in headers
+func headerValue(headers []http.Header, key string) string {
+	for _, h := range headers {
+		if v := h.Get(key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// etagMatch reports whether etag is in the list of header value, weak tags only match when weak is true
+func etagMatch(list, etag string, weak bool) bool {
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPreconditions evaluates If-Match, If-Unmodified-Since and If-None-Match of headers against file path
+func checkPreconditions(path string, headers ...http.Header) error {
+	ifMatch := headerValue(headers, "If-Match")
+	ifUnmodified := headerValue(headers, "If-Unmodified-Since")
+	ifNoneMatch := headerValue(headers, "If-None-Match")
+	if ifMatch == "" && ifUnmodified == "" && ifNoneMatch == "" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	exists := err == nil
+	etag := ""
+	if exists && (ifMatch != "" || ifNoneMatch != "") {
+		if etag, err = fileETag(path); err != nil {
+			return err
+		}
+	}
+	fail := func(format string, args ...interface{}) error {
+		return &preconditionError{Message: fmt.Sprintf(format, args...), Current: statFileVersion(path)}
+	}
+
+	if ifMatch != "" {
+		if !exists {
+			return fail("Precondition failed: file not exists")
+		}
+		if strings.TrimSpace(ifMatch) != "*" && !etagMatch(ifMatch, etag, false) {
+			return fail("Precondition failed: file has been changed, current etag is %s", etag)
+		}
+	} else if ifUnmodified != "" && exists {
+		t, err := http.ParseTime(ifUnmodified)
+		if err != nil {
+			return newStatusError(http.StatusBadRequest, "Invalid If-Unmodified-Since: %s", ifUnmodified)
+		}
+		if info.ModTime().Truncate(time.Second).After(t) {
+			return fail("Precondition failed: file has been modified at %s", info.ModTime().UTC().Format(http.TimeFormat))
+		}
+	}
+	if ifNoneMatch != "" && exists {
+		if strings.TrimSpace(ifNoneMatch) == "*" {
+			return fail("Precondition failed: file already exists")
+		}
+		if etagMatch(ifNoneMatch, etag, true) {
+			return fail("Precondition failed: file matches etag %s", etag)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckPreconditions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.txt")
+	if err = ioutil.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(path)
+	etag, _ := fileETag(path)
+	past := info.ModTime().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	future := info.ModTime().Add(time.Hour).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		path   string
+		header string
+		value  string
+		pass   bool
+	}{
+		{path, "If-Match", etag, true},
+		{path, "If-Match", `"1-2", ` + etag, true},
+		{path, "If-Match", `"1-2"`, false},
+		{path, "If-Match", "W/" + etag, false},
+		{path, "If-Match", "*", true},
+		{path + ".new", "If-Match", "*", false},
+		{path, "If-None-Match", "*", false},
+		{path + ".new", "If-None-Match", "*", true},
+		{path, "If-None-Match", "W/" + etag, false},
+		{path, "If-Unmodified-Since", future, true},
+		{path, "If-Unmodified-Since", past, false},
+	}
+	for _, v := range tests {
+		header := http.Header{}
+		header.Set(v.header, v.value)
+		err := checkPreconditions(v.path, header)
+		if (err == nil) != v.pass {
+			t.Fatalf("Failed: %v - err:%v", v, err)
+		}
+	}
+}
+
+func TestFileETagSameStat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.txt")
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	var etags []string
+	// same size and mtime, like two saves within the timestamp granularity of a filesystem
+	for _, content := range []string{"aaaaa", "bbbbb", "bbbbb"} {
+		tmp := filepath.Join(dir, "tmp")
+		ioutil.WriteFile(tmp, []byte(content), 0644)
+		os.Chtimes(tmp, mtime, mtime)
+		os.Rename(tmp, path)
+		etag, err := fileETag(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		etags = append(etags, etag)
+	}
+	if etags[0] == etags[1] || etags[1] == etags[2] {
+		t.Fatalf("etags: %v", etags)
+	}
+	if etag, _ := fileETag(path); etag != etags[2] {
+		t.Fatalf("etag of unchanged file: %s != %s", etag, etags[2])
+	}
+}
@@ -41,6 +41,9 @@ var vm = new Vue({
     version: "loading",
     mtimeTypeFromNow: false, // or fromNow
     auth: {},
+    aclTemplates: [],
+    admin: false,
+    acl: {},
     search: getQueryString("search"),
     files: [{
       name: "loading ...",
@@ -114,6 +117,13 @@ var vm = new Vue({
       maxFilesize: 1024,
       addRemoveLinks: true,
       init: function() {
+        this.on("sending", function(file, xhr, formData) {
+          // keep directory structure of dropped folders
+          var relativePath = file.fullPath || file.webkitRelativePath;
+          if (relativePath) {
+            formData.append("relativePath", relativePath);
+          }
+        });
         this.on("uploadprogress", function(file, progress) {
           // console.log("File progress", progress);
         });
@@ -145,7 +155,11 @@ var vm = new Vue({
           url: pathJoin(["/-/mkdir", location.pathname]),
           dataType:"text",
           method: "POST",
-          data:{"folderName" : folderName},
+          data:{
+            "folderName": folderName,
+            "parents": "true",
+            "template": $("#mkdir-template").val() || "",
+          },
           success: function(res) {
             console.log("mkdir successfully");
             // clear input
@@ -170,7 +184,10 @@ var vm = new Vue({
       $.ajax({
         url: pathJoin([location.pathname, f.name]),
         method: "GET",
-        success: function(res) {
+        cache: false,
+        success: function(res, status, xhr) {
+          // sent back when saving, so changes of others are not overwritten
+          $("#file-edit-text-area").data("etag", xhr.getResponseHeader("ETag"));
           $("#file-edit-title").text(f.name);
           $("#file-edit-text-area").val(res);
           $("#file-edit-modal").modal("show");
@@ -186,11 +203,15 @@ var vm = new Vue({
     saveEditFile: function() {
       var fileContent = $("#file-edit-text-area").val();
       var fileName = $("#file-edit-title").text();
+      var etag = $("#file-edit-text-area").data("etag");
       $.ajax({
           url: pathJoin([location.pathname, fileName]),
           dataType: "text",
           method: "PUT",
-          data:{"content" : fileContent},
+          headers: etag ? {"If-Match": etag} : {},
+          contentType: "text/plain;charset=utf-8",
+          processData: false,
+          data: fileContent,
           success: function(res) {
             $("#file-edit-modal").modal("hide");
             // reload files
@@ -198,11 +219,56 @@ var vm = new Vue({
           },
           error:function(res){
             console.error(res);
-            $('#edit-error-alert span').text(res.responseText);
+            var message = res.responseText;
+            if (res.status == 412) {
+              message = "File has been changed by someone else, reopen it to edit the latest version";
+            }
+            $('#edit-error-alert span').text(message);
             $('#edit-error-alert').fadeIn('slow').delay(3000).fadeOut('slow');
           }
         })
     },
+    // load .ghs.yml of current directory as JSON, only for admins
+    showACL: function() {
+      $.ajax({
+        url: pathJoin(["/-/acl", location.pathname]),
+        dataType: "json",
+        cache: false,
+        success: function(res, status, xhr) {
+          vm.acl = res;
+          $("#acl-text-area").data("etag", xhr.getResponseHeader("ETag"));
+          $("#acl-text-area").val(JSON.stringify(res.conf, null, 2));
+          $("#acl-modal").modal("show");
+        },
+        error: function(err) {
+          console.error(err);
+        }
+      })
+    },
+    saveACL: function() {
+      var etag = $("#acl-text-area").data("etag");
+      $.ajax({
+        url: pathJoin(["/-/acl", location.pathname]),
+        method: "PUT",
+        // a new file must not exist yet, so concurrent creations are not overwritten either
+        headers: etag ? {"If-Match": etag} : {"If-None-Match": "*"},
+        contentType: "application/json;charset=utf-8",
+        processData: false,
+        data: $("#acl-text-area").val(),
+        success: function(res) {
+          $("#acl-modal").modal("hide");
+          loadFileList();
+        },
+        error: function(res) {
+          var message = res.responseText;
+          if (res.status == 412) {
+            message = ".ghs.yml has been changed by someone else, reopen it to edit the latest version";
+          }
+          $('#acl-error-alert span').text(message);
+          $('#acl-error-alert').fadeIn('slow').delay(3000).fadeOut('slow');
+        }
+      })
+    },
     genInstallURL: function(name) {
       var urlPath;
       if (getExtention(name) == "ipa") {
@@ -315,6 +381,26 @@ var vm = new Vue({
         }
       });
     },
+    // action is move or copy, destination is a path from root
+    moveOrCopyPath: function(f, action, e) {
+      e.preventDefault();
+      var src = decodeURI(pathJoin([location.pathname, f.name]));
+      var dst = prompt(action == "move" ? "Move or rename to" : "Copy to", src);
+      if (!dst || dst == src) {
+        return;
+      }
+      $.ajax({
+        url: "/-/" + action,
+        method: "POST",
+        data: {src: src, dst: dst},
+        success: function(res) {
+          loadFileList()
+        },
+        error: function(err) {
+          alert(err.responseText);
+        }
+      });
+    },
     updateBreadcrumb: function() {
       var pathname = decodeURI(location.pathname || "/");
       var parts = pathname.split('/');
@@ -392,6 +478,8 @@ function loadFileList(pathname) {
 
         vm.files = res.files;
         vm.auth = res.auth;
+        vm.aclTemplates = res.aclTemplates || [];
+        vm.admin = res.admin;
       },
       error: function(err) {
         console.error(err)
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// clientIDHeader identifies a client in staged rollouts, client IP is used when absent
+const clientIDHeader = "X-Client-Id"
+
+// rolloutBucket maps client of request to [0, 100), stable for the same candidate.
+// Raising the rollout percentage keeps all clients which already got the candidate.
+func rolloutBucket(req *http.Request, path, candidate string) int {
+	clientID := req.Header.Get(clientIDHeader)
+	if clientID == "" {
+		clientID = getRealIP(req)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(filepath.ToSlash(filepath.Clean(path)) + "\x00" + candidate + "\x00" + clientID))
+	return int(h.Sum32() % 100)
+}
+
+// hRollout controls staged rollout of checkout directory by form value "action"
+//
+//	start:    start rollout of "file" to "percent" of clients
+//	raise:    change percentage to "percent"
+//	pause:    stop serving candidate, percentage is kept
+//	resume:   continue a paused rollout
+//	abort:    stop rollout, everyone gets the checked file
+//	complete: promote candidate to the checked file
+func (s *HTTPStaticServer) hRollout(w http.ResponseWriter, req *http.Request) {
+	path := mux.Vars(req)["path"]
+	if !s.accessible(path) {
+		http.NotFound(w, req)
+		return
+	}
+	fileWriteMu.Lock()
+	defer fileWriteMu.Unlock()
+	relPath := filepath.Join(s.Root, path)
+	auth := s.readAccessConf(path)
+	if !auth.canUpload(req) {
+		http.Error(w, "Rollout forbidden", http.StatusForbidden)
+		return
+	}
+	if !isDir(relPath) {
+		http.Error(w, "Rollout forbidden: directory not exists "+path, http.StatusForbidden)
+		return
+	}
+	action := req.FormValue("action")
+	percent := auth.Rollout
+	if action == "start" || action == "raise" {
+		var err error
+		percent, err = strconv.Atoi(req.FormValue("percent"))
+		if err != nil || percent < 0 || percent > 100 {
+			http.Error(w, "Rollout failed: percent should be 0-100", http.StatusBadRequest)
+			return
+		}
+	}
+	if action != "start" && auth.Candidate == "" {
+		http.Error(w, "Rollout failed: no rollout in progress in "+path, http.StatusConflict)
+		return
+	}
+
+	candidate := auth.Candidate
+	var values map[string]interface{}
+	switch action {
+	case "start":
+		candidate = req.FormValue("file")
+		if !s.validChannelFile(path, candidate) {
+			http.Error(w, "Rollout failed: not valid file "+strconv.Quote(candidate), http.StatusBadRequest)
+			return
+		}
+		values = map[string]interface{}{"candidate": candidate, "rollout": percent, "rolloutPaused": nil}
+	case "raise":
+		values = map[string]interface{}{"rollout": percent}
+	case "pause":
+		values = map[string]interface{}{"rolloutPaused": true}
+	case "resume":
+		values = map[string]interface{}{"rolloutPaused": nil}
+	case "abort", "complete":
+		values = map[string]interface{}{"candidate": nil, "rollout": nil, "rolloutPaused": nil}
+	default:
+		http.Error(w, "Rollout failed: unknown action "+strconv.Quote(action), http.StatusBadRequest)
+		return
+	}
+	if err := updateAccessConf(relPath, values); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var entry ChannelEntry
+	var err error
+	if action == "complete" {
+		entry, err = s.setChannelFile(req, path, "promote", candidate, nil)
+	} else {
+		entry = ChannelEntry{
+			Action:   "rollout-" + action,
+			File:     candidate,
+			Previous: auth.Checked,
+			Percent:  percent,
+			IP:       getRealIP(req),
+			Time:     time.Now().UnixNano() / 1e6,
+		}
+		if user := currentUser(req); user != nil {
+			entry.User = user.Email
+		}
+		err = appendChannelHistory(relPath, entry)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"entry":   entry,
+	})
+}
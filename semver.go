@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version, missing minor or patch parts are zero
+type Version struct {
+	Major, Minor, Patch int
+	Pre                 string
+	parts               int // number of numeric parts given, used by constraints like "1.4"
+}
+
+var (
+	versionRegx         = regexp.MustCompile(`^[vV]?(\d+)(?:\.(\d+|[xX*]))?(?:\.(\d+|[xX*]))?(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+	fileNameVersionRegx = regexp.MustCompile(`(?:^|[^0-9A-Za-z.])[vV]?(\d+)\.(\d+)(?:\.(\d+))?(?:-([0-9A-Za-z][0-9A-Za-z-]*(?:\.[0-9A-Za-z-]+)*))?`)
+	digitsRegx          = regexp.MustCompile(`^\d+$`)
+)
+
+func parseVersion(s string) (*Version, error) {
+	m := versionRegx.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return nil, fmt.Errorf("invalid version %s", strconv.Quote(s))
+	}
+	v := &Version{Pre: m[4]}
+	v.Major, _ = strconv.Atoi(m[1])
+	v.parts = 1
+	for i, p := range []*int{&v.Minor, &v.Patch} {
+		if !digitsRegx.MatchString(m[i+2]) {
+			break // wildcard or missing
+		}
+		*p, _ = strconv.Atoi(m[i+2])
+		v.parts++
+	}
+	return v, nil
+}
+
+// versionFromFileName extracts version from names like "app-1.4.2.apk" or "tool_v2.0.0-rc.1.tar.gz",
+// nil is returned if name contains no version.
+func versionFromFileName(name string) *Version {
+	// strip extensions like ".apk" and ".tar.gz" but keep the ones which are part of version
+	for ext := filepath.Ext(name); len(ext) > 1 && (ext[1] < '0' || ext[1] > '9'); ext = filepath.Ext(name) {
+		name = strings.TrimSuffix(name, ext)
+	}
+	m := fileNameVersionRegx.FindStringSubmatch(name)
+	if m == nil {
+		return nil
+	}
+	v := &Version{Pre: m[4], parts: 3}
+	v.Major, _ = strconv.Atoi(m[1])
+	v.Minor, _ = strconv.Atoi(m[2])
+	v.Patch, _ = strconv.Atoi(m[3])
+	return v
+}
+
+func (v *Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 when v is less than, equal to or greater than o
+func (v *Version) Compare(o *Version) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d != 0 {
+			return sign(d)
+		}
+	}
+	return comparePrerelease(v.Pre, o.Pre)
+}
+
+func comparePrerelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "": // release is greater than any pre-release
+		return 1
+	case b == "":
+		return -1
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		switch {
+		case aerr == nil && berr == nil:
+			return sign(an - bn)
+		case aerr == nil: // numeric identifiers have lower precedence
+			return -1
+		case berr == nil:
+			return 1
+		}
+		return sign(strings.Compare(as[i], bs[i]))
+	}
+	return sign(len(as) - len(bs))
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// versionRange is [lower, upper) or with inclusive bounds, nil means unbounded
+type versionRange struct {
+	lower, upper         *Version
+	lowerIncl, upperIncl bool
+}
+
+func (r versionRange) contains(v *Version) bool {
+	if r.lower != nil {
+		c := v.Compare(r.lower)
+		if c < 0 || (c == 0 && !r.lowerIncl) {
+			return false
+		}
+	}
+	if r.upper != nil {
+		c := v.Compare(r.upper)
+		if c > 0 || (c == 0 && !r.upperIncl) {
+			return false
+		}
+	}
+	return true
+}
+
+// VersionConstraint is a list of alternatives separated by "||",
+// each alternative is a list of ranges which should all be satisfied.
+//
+// Supported forms: "^1.4", "~2.0", "1.4" (same as 1.4.x), "1.4.2", ">=1.2 <2", "*"
+type VersionConstraint struct {
+	alternatives [][]versionRange
+	pre          bool // pre-release versions are only matched when constraint mentions one
+}
+
+func parseVersionConstraint(s string) (*VersionConstraint, error) {
+	c := &VersionConstraint{pre: strings.Contains(s, "-")}
+	for _, alt := range strings.Split(s, "||") {
+		var ranges []versionRange
+		for _, term := range strings.FieldsFunc(alt, func(r rune) bool { return r == ',' || r == ' ' }) {
+			r, err := parseVersionRange(term)
+			if err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, r)
+		}
+		if len(ranges) == 0 {
+			return nil, errors.New("empty version constraint")
+		}
+		c.alternatives = append(c.alternatives, ranges)
+	}
+	return c, nil
+}
+
+func parseVersionRange(term string) (r versionRange, err error) {
+	if term == "*" || term == "x" || term == "X" {
+		return
+	}
+	op := term[:len(term)-len(strings.TrimLeft(term, "^~<>="))]
+	v, err := parseVersion(term[len(op):])
+	if err != nil {
+		return
+	}
+	// the first version which is out of range when bumping part i
+	bump := func(i int) *Version {
+		switch i {
+		case 0:
+			return &Version{Major: v.Major + 1}
+		case 1:
+			return &Version{Major: v.Major, Minor: v.Minor + 1}
+		}
+		return &Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+	}
+	switch op {
+	case "^":
+		r.lower, r.lowerIncl = v, true
+		// bump the first non-zero part, ^0.2.3 means <0.3.0
+		switch {
+		case v.Major != 0 || v.parts == 1:
+			r.upper = bump(0)
+		case v.Minor != 0 || v.parts == 2:
+			r.upper = bump(1)
+		default:
+			r.upper = bump(2)
+		}
+	case "~":
+		r.lower, r.lowerIncl = v, true
+		if v.parts == 1 {
+			r.upper = bump(0)
+		} else {
+			r.upper = bump(1)
+		}
+	case "", "=":
+		if v.parts == 3 {
+			r.lower, r.lowerIncl, r.upper, r.upperIncl = v, true, v, true
+		} else {
+			r.lower, r.lowerIncl, r.upper = v, true, bump(v.parts-1)
+		}
+	case ">":
+		if v.parts == 3 {
+			r.lower = v
+		} else {
+			r.lower, r.lowerIncl = bump(v.parts-1), true
+		}
+	case ">=":
+		r.lower, r.lowerIncl = v, true
+	case "<":
+		r.upper = v
+	case "<=":
+		if v.parts == 3 {
+			r.upper, r.upperIncl = v, true
+		} else {
+			r.upper = bump(v.parts - 1)
+		}
+	default:
+		err = fmt.Errorf("invalid version constraint %s", strconv.Quote(term))
+	}
+	return
+}
+
+// Check reports whether version v satisfies the constraint
+func (c *VersionConstraint) Check(v *Version) bool {
+	if v.Pre != "" && !c.pre {
+		return false
+	}
+	for _, ranges := range c.alternatives {
+		ok := true
+		for _, r := range ranges {
+			if !r.contains(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func TestVersionFromFileName(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+	}{
+		{"app-1.4.2.apk", "1.4.2"},
+		{"app-1.4.apk", "1.4.0"},
+		{"tool_v2.0.0-rc.1.tar.gz", "2.0.0-rc.1"},
+		{"app-10.0.1", "10.0.1"},
+		{"app.apk", ""},
+		{"app1.4.2.apk", ""},
+	}
+	for _, v := range tests {
+		ver := versionFromFileName(v.name)
+		res := ""
+		if ver != nil {
+			res = ver.String()
+		}
+		if res != v.version {
+			t.Fatalf("Failed: %v - res:%v", v, res)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		res  int
+	}{
+		{"1.4.2", "1.4.2", 0},
+		{"1.10.0", "1.9.0", 1},
+		{"1.4.2-rc.1", "1.4.2", -1},
+		{"1.4.2-rc.2", "1.4.2-rc.10", -1},
+		{"1.4.2-beta", "1.4.2-alpha", 1},
+		{"v2", "1.99.99", 1},
+	}
+	for _, v := range tests {
+		a, _ := parseVersion(v.a)
+		b, _ := parseVersion(v.b)
+		if res := a.Compare(b); res != v.res {
+			t.Fatalf("Failed: %v - res:%v", v, res)
+		}
+	}
+}
+
+func TestVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		pass       bool
+	}{
+		{"^1.4", "1.4.0", true},
+		{"^1.4", "1.9.3", true},
+		{"^1.4", "2.0.0", false},
+		{"^1.4", "1.3.9", false},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"~2.0", "2.0.7", true},
+		{"~2.0", "2.1.0", false},
+		{"1.4", "1.4.5", true},
+		{"1.4", "1.5.0", false},
+		{"1.4.2", "1.4.2", true},
+		{"1.4.2", "1.4.3", false},
+		{">=1.2 <2", "1.9.0", true},
+		{">=1.2, <2", "2.0.0", false},
+		{"<=1.4", "1.4.9", true},
+		{">1.4", "1.4.9", false},
+		{"^1 || ^3", "3.1.0", true},
+		{"^1.4", "1.5.0-rc.1", false},
+		{"^1.5.0-rc", "1.5.0-rc.1", true},
+		{"*", "0.0.1", true},
+	}
+	for _, v := range tests {
+		c, err := parseVersionConstraint(v.constraint)
+		if err != nil {
+			t.Fatalf("Failed: %v - err:%v", v, err)
+		}
+		ver, _ := parseVersion(v.version)
+		if res := c.Check(ver); res != v.pass {
+			t.Fatalf("Failed: %v - res:%v", v, res)
+		}
+	}
+	for _, expr := range []string{"", "^", "~abc", "!1.0"} {
+		if _, err := parseVersionConstraint(expr); err == nil {
+			t.Fatalf("Failed: %q should be invalid", expr)
+		}
+	}
+}
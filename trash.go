@@ -0,0 +1,277 @@
+package main
+
+// Recycle bin, deleted files and directories are moved to the trash and removed after --trash-days.
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type TrashItem struct {
+	ID      string `json:"id"`
+	Path    string `json:"path"` // where it was deleted from
+	IsDir   bool   `json:"isDir"`
+	Size    int64  `json:"size"`
+	User    string `json:"user,omitempty"`
+	IP      string `json:"ip"`
+	Time    int64  `json:"time"`    // milliseconds, when deleted
+	Expires int64  `json:"expires"` // milliseconds
+}
+
+type byTrashTime []TrashItem
+
+func (items byTrashTime) Len() int           { return len(items) }
+func (items byTrashTime) Swap(i, j int)      { items[i], items[j] = items[j], items[i] }
+func (items byTrashTime) Less(i, j int) bool { return items[i].Time > items[j].Time }
+
+func (s *HTTPStaticServer) trashDir() string {
+	return filepath.Join(s.Root, internalDir, "trash")
+}
+
+// trashDataPath is where the deleted item is kept, named as it was
+func (s *HTTPStaticServer) trashDataPath(item *TrashItem) string {
+	return filepath.Join(s.trashDir(), item.ID, filepath.Base(item.Path))
+}
+
+func (s *HTTPStaticServer) removeTrashItem(id string) error {
+	if err := os.RemoveAll(filepath.Join(s.trashDir(), id)); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(s.trashDir(), id+".json"))
+}
+
+func (s *HTTPStaticServer) loadTrashItem(id string) (*TrashItem, error) {
+	// id is generated by server, anything else could be a path traversal
+	if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+		return nil, os.ErrNotExist
+	}
+	data, err := ioutil.ReadFile(filepath.Join(s.trashDir(), id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	item := new(TrashItem)
+	err = json.Unmarshal(data, item)
+	return item, err
+}
+
+// listTrash returns trashed items, the latest deleted first
+func (s *HTTPStaticServer) listTrash() ([]TrashItem, error) {
+	matches, err := filepath.Glob(filepath.Join(s.trashDir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	items := make([]TrashItem, 0, len(matches))
+	for _, match := range matches {
+		item, err := s.loadTrashItem(strings.TrimSuffix(filepath.Base(match), ".json"))
+		if err == nil {
+			items = append(items, *item)
+		}
+	}
+	sort.Sort(byTrashTime(items))
+	return items, nil
+}
+
+// moveToTrash moves file or directory path into the trash
+func (s *HTTPStaticServer) moveToTrash(path, user, ip string) (*TrashItem, error) {
+	localPath := filepath.Join(s.Root, path)
+	info, err := os.Lstat(localPath)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	item := &TrashItem{
+		Path:    strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "/"),
+		IsDir:   info.IsDir(),
+		Size:    info.Size(),
+		User:    user,
+		IP:      ip,
+		Time:    now.UnixNano() / 1e6,
+		Expires: now.AddDate(0, 0, s.TrashDays).UnixNano() / 1e6,
+	}
+	if info.IsDir() {
+		item.Size = 0
+		filepath.Walk(localPath, func(_ string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				item.Size += info.Size()
+			}
+			return nil
+		})
+	}
+	if err = os.MkdirAll(s.trashDir(), 0755); err != nil {
+		return nil, err
+	}
+	for {
+		item.ID = strconv.FormatInt(now.UnixNano(), 10)
+		if err = os.Mkdir(filepath.Join(s.trashDir(), item.ID), 0755); !os.IsExist(err) {
+			break
+		}
+		now = now.Add(1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Rename(localPath, s.trashDataPath(item)); err != nil {
+		os.Remove(filepath.Join(s.trashDir(), item.ID))
+		return nil, err
+	}
+	data, _ := json.Marshal(item)
+	if err = ioutil.WriteFile(filepath.Join(s.trashDir(), item.ID+".json"), data, 0644); err != nil {
+		os.Rename(s.trashDataPath(item), localPath)
+		os.Remove(filepath.Join(s.trashDir(), item.ID))
+		return nil, err
+	}
+	return item, nil
+}
+
+// cleanTrash removes expired items
+func (s *HTTPStaticServer) cleanTrash() {
+	items, err := s.listTrash()
+	if err != nil {
+		return
+	}
+	now := time.Now().UnixNano() / 1e6
+	for _, item := range items {
+		if now > item.Expires {
+			log.Printf("Remove expired trash: %s %s", item.ID, item.Path)
+			s.removeTrashItem(item.ID)
+		}
+	}
+}
+
+// hTrashList lists items deleted from directory path, which the user could delete
+func (s *HTTPStaticServer) hTrashList(w http.ResponseWriter, req *http.Request) {
+	prefix := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(mux.Vars(req)["path"])), "/")
+	if prefix == "." {
+		prefix = ""
+	}
+	items, err := s.listTrash()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	visible := make([]TrashItem, 0, len(items))
+	for _, item := range items {
+		if prefix != "" && item.Path != prefix && !strings.HasPrefix(item.Path, prefix+"/") {
+			continue
+		}
+		auth := s.readAccessConf(item.Path)
+		if s.accessible(item.Path) && auth.canDelete(req) {
+			visible = append(visible, item)
+		}
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"items": visible,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// hTrashRestore moves item back to where it was deleted from. When the path is taken,
+// "onConflict" decides: overwrite moves the existing one to trash, rename and version-suffix restore with a new name.
+func (s *HTTPStaticServer) hTrashRestore(w http.ResponseWriter, req *http.Request) {
+	item, err := s.loadTrashItem(mux.Vars(req)["id"])
+	if err != nil || !s.accessible(item.Path) {
+		http.Error(w, "Trash item not found", http.StatusNotFound)
+		return
+	}
+	auth := s.readAccessConf(item.Path)
+	if !auth.canUpload(req) {
+		http.Error(w, "Restore forbidden", http.StatusForbidden)
+		return
+	}
+	if err = s.checkAccessConfWrite(req, item.Path); err != nil {
+		httpError(w, err)
+		return
+	}
+	policy, err := requestConflictPolicy(req, s.readAccessConf(filepath.Dir(item.Path)))
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	// parents deleted since are created again, as by mkdir in the nearest existing one
+	existing, err := s.existingParent(item.Path)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	if parent := s.readAccessConf(existing); existing != filepath.Dir(item.Path) && !parent.canMKDir(req) {
+		http.Error(w, "Restore forbidden: directory not exists "+filepath.ToSlash(filepath.Dir(item.Path)), http.StatusForbidden)
+		return
+	}
+	dstPath := filepath.Join(s.Root, item.Path)
+	if err = os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	user, ip := requestAuthor(req)
+	fileWriteMu.Lock()
+	if _, statErr := os.Lstat(dstPath); statErr == nil {
+		switch policy {
+		case conflictOverwrite:
+			if err = s.checkOverwrite(req, item.Path, item.IsDir); err == nil {
+				_, err = s.moveToTrash(item.Path, user, ip)
+			}
+		case conflictReject:
+			err = newStatusError(http.StatusConflict, "Restore failed: %s already exists", item.Path)
+		default:
+			now := time.Now()
+			err = newStatusError(http.StatusConflict, "Restore failed: too many files named like %s", item.Path)
+			for i := 1; i < 1000; i++ {
+				dstPath = conflictName(filepath.Join(s.Root, item.Path), policy, i, now)
+				if _, statErr = os.Lstat(dstPath); os.IsNotExist(statErr) {
+					err = nil
+					break
+				}
+			}
+		}
+	}
+	if err == nil {
+		err = os.Rename(s.trashDataPath(item), dstPath)
+	}
+	fileWriteMu.Unlock()
+	invalidateAccessConf()
+	if err != nil {
+		log.Println("Restore trash:", err)
+		httpError(w, err)
+		return
+	}
+	s.removeTrashItem(item.ID)
+
+	relPath, _ := filepath.Rel(s.Root, dstPath)
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"path":    filepath.ToSlash(relPath),
+		"item":    item,
+	})
+}
+
+// hTrashPurge removes item from trash permanently
+func (s *HTTPStaticServer) hTrashPurge(w http.ResponseWriter, req *http.Request) {
+	item, err := s.loadTrashItem(mux.Vars(req)["id"])
+	if err != nil || !s.accessible(item.Path) {
+		http.Error(w, "Trash item not found", http.StatusNotFound)
+		return
+	}
+	auth := s.readAccessConf(item.Path)
+	if !auth.canDelete(req) {
+		http.Error(w, "Purge forbidden", http.StatusForbidden)
+		return
+	}
+	if err = s.removeTrashItem(item.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrashRestoreParents(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "a", "b"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "a", "b", "f.txt"), []byte("f"), 0644)
+	s := NewHTTPStaticServer(root)
+	s.Upload, s.Delete = true, true
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("DELETE", "/a/b/f.txt", nil))
+	id := w.Header().Get("X-Trash-Id")
+	if w.Code != http.StatusOK || id == "" {
+		t.Fatalf("Failed: delete - code:%d %s", w.Code, w.Body.String())
+	}
+	os.RemoveAll(filepath.Join(root, "a", "b"))
+
+	tests := []struct {
+		mkdir bool
+		code  int
+	}{
+		{false, http.StatusForbidden},
+		{true, http.StatusOK},
+	}
+	for _, v := range tests {
+		s.MKDir = v.mkdir
+		invalidateAccessConf()
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("POST", "/-/trash/-/"+id, nil))
+		if w.Code != v.code {
+			t.Fatalf("Failed: %v - code:%d %s", v, w.Code, w.Body.String())
+		}
+		if restored := isFile(filepath.Join(root, "a", "b", "f.txt")); restored != (v.code == http.StatusOK) {
+			t.Fatalf("Failed: %v - restored:%v", v, restored)
+		}
+	}
+}
+
+func TestTrashRestoreOverwrite(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	victim := filepath.Join(root, "victim")
+	ioutil.WriteFile(victim, []byte("trashed"), 0644)
+	s := NewHTTPStaticServer(root)
+	s.Upload, s.Delete = true, true
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("DELETE", "/victim", nil))
+	id := w.Header().Get("X-Trash-Id")
+	if w.Code != http.StatusOK || id == "" {
+		t.Fatalf("Failed: delete - code:%d %s", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		dir    bool // victim is a directory
+		delete bool
+		code   int
+	}{
+		{true, true, http.StatusConflict},
+		{false, false, http.StatusForbidden},
+		{false, true, http.StatusOK},
+	}
+	for _, v := range tests {
+		os.RemoveAll(victim)
+		if v.dir {
+			os.MkdirAll(filepath.Join(victim, "deep"), 0755)
+		} else {
+			ioutil.WriteFile(victim, []byte("current"), 0644)
+		}
+		s.Delete = v.delete
+		invalidateAccessConf()
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("POST", "/-/trash/-/"+id, nil))
+		if w.Code != v.code {
+			t.Fatalf("Failed: %v - code:%d %s", v, w.Code, w.Body.String())
+		}
+		expect := "trashed"
+		if v.code != http.StatusOK {
+			expect = "current"
+		}
+		if v.dir {
+			if !isDir(filepath.Join(victim, "deep")) {
+				t.Fatalf("Failed: %v - directory replaced", v)
+			}
+		} else if data, _ := ioutil.ReadFile(victim); string(data) != expect {
+			t.Fatalf("Failed: %v - content:%q", v, data)
+		}
+	}
+}
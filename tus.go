@@ -0,0 +1,386 @@
+package main
+
+// Resumable uploads, implements the tus protocol 1.0.0 (https://tus.io/protocols/resumable-upload.html)
+// with creation, expiration and termination extensions.
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,termination"
+)
+
+type tusUpload struct {
+	ID       string            `json:"id"`
+	Path     string            `json:"path"` // target directory
+	Filename string            `json:"filename"`
+	Length   int64             `json:"length"`
+	Metadata map[string]string `json:"metadata"`
+	Expires  int64             `json:"expires"` // unix seconds
+	User     string            `json:"user,omitempty"`
+	IP       string            `json:"ip"`
+	Conflict string            `json:"onConflict"` // conflict policy when finished
+}
+
+// uploads being patched, the same upload can not be patched concurrently
+var (
+	tusBusy   = make(map[string]bool)
+	tusBusyMu sync.Mutex
+)
+
+func (s *HTTPStaticServer) tusDir() string {
+	return filepath.Join(s.Root, internalDir, "uploads")
+}
+
+func (s *HTTPStaticServer) tusInfoPath(id string) string {
+	return filepath.Join(s.tusDir(), id+".json")
+}
+
+func (s *HTTPStaticServer) tusDataPath(id string) string {
+	return filepath.Join(s.tusDir(), id+".bin")
+}
+
+func (s *HTTPStaticServer) loadTusUpload(id string) (*tusUpload, error) {
+	// id is generated by server, anything else could be a path traversal
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return nil, os.ErrNotExist
+	}
+	data, err := ioutil.ReadFile(s.tusInfoPath(id))
+	if err != nil {
+		return nil, err
+	}
+	up := new(tusUpload)
+	err = json.Unmarshal(data, up)
+	return up, err
+}
+
+func (s *HTTPStaticServer) saveTusUpload(up *tusUpload) error {
+	data, err := json.Marshal(up)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.tusInfoPath(up.ID), data, 0644)
+}
+
+func (s *HTTPStaticServer) removeTusUpload(id string) {
+	os.Remove(s.tusDataPath(id))
+	os.Remove(s.tusInfoPath(id))
+}
+
+// parseTusMetadata decodes "Upload-Metadata: filename d29ybGQudHh0,is_confidential"
+func parseTusMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+		var value []byte
+		if len(fields) > 1 {
+			var err error
+			if value, err = base64.StdEncoding.DecodeString(fields[1]); err != nil {
+				return nil, err
+			}
+		}
+		meta[fields[0]] = string(value)
+	}
+	return meta, nil
+}
+
+func setTusHeaders(w http.ResponseWriter, up *tusUpload) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if up != nil {
+		w.Header().Set("Upload-Expires", time.Unix(up.Expires, 0).UTC().Format(http.TimeFormat))
+	}
+}
+
+// hTusOptions tells clients what the server supports
+func (s *HTTPStaticServer) hTusOptions(w http.ResponseWriter, req *http.Request) {
+	setTusHeaders(w, nil)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func checkTusResumable(w http.ResponseWriter, req *http.Request) bool {
+	setTusHeaders(w, nil)
+	if req.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// hTusCreate creates an upload of file into directory path, file name is given by "filename" in metadata
+func (s *HTTPStaticServer) hTusCreate(w http.ResponseWriter, req *http.Request) {
+	if !checkTusResumable(w, req) {
+		return
+	}
+	path := mux.Vars(req)["path"]
+	auth := s.readAccessConf(path)
+	if !auth.canUpload(req) {
+		http.Error(w, "Upload forbidden", http.StatusForbidden)
+		return
+	}
+	if !isDir(filepath.Join(s.Root, path)) {
+		http.Error(w, "Upload forbidden: directory not exists "+path, http.StatusForbidden)
+		return
+	}
+	length, err := strconv.ParseInt(req.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	meta, err := parseTusMetadata(req.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "Invalid Upload-Metadata: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	filename := meta["filename"]
+	if filename == "" {
+		filename = meta["name"]
+	}
+	filename = filepath.Base(sanitizedName(filename))
+	if filename == "." || filename == "/" || filename == ".." {
+		http.Error(w, "Upload-Metadata should contain filename", http.StatusBadRequest)
+		return
+	}
+	if !s.accessible(filepath.Join(path, filename)) {
+		http.NotFound(w, req)
+		return
+	}
+	if err = s.checkAccessConfWrite(req, filepath.Join(path, filename)); err != nil {
+		httpError(w, err)
+		return
+	}
+	policy, err := requestConflictPolicy(req, auth)
+	if err == nil {
+		err = checkConflict(filepath.Join(s.Root, path, filename), policy)
+	}
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err = rand.Read(idBytes); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	up := &tusUpload{
+		ID:       hex.EncodeToString(idBytes),
+		Path:     path,
+		Filename: filename,
+		Length:   length,
+		Metadata: meta,
+		Expires:  time.Now().Add(s.UploadExpire).Unix(),
+		IP:       getRealIP(req),
+		Conflict: policy,
+	}
+	if user := currentUser(req); user != nil {
+		up.User = user.Email
+	}
+	if err = os.MkdirAll(s.tusDir(), 0755); err == nil {
+		err = ioutil.WriteFile(s.tusDataPath(up.ID), nil, 0644)
+	}
+	if err == nil {
+		err = s.saveTusUpload(up)
+	}
+	if err == nil && length == 0 {
+		err = s.finishTusUpload(up)
+	}
+	if err != nil {
+		s.removeTusUpload(up.ID)
+		log.Println("Create tus upload:", err)
+		httpError(w, err)
+		return
+	}
+	setTusHeaders(w, up)
+	w.Header().Set("Location", "/-/tus/-/"+up.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// lookupTusUpload loads upload of request and checks whether it is still writable by the user
+func (s *HTTPStaticServer) lookupTusUpload(w http.ResponseWriter, req *http.Request) *tusUpload {
+	if req.Method != "HEAD" && !checkTusResumable(w, req) {
+		return nil
+	}
+	setTusHeaders(w, nil)
+	up, err := s.loadTusUpload(mux.Vars(req)["id"])
+	// the destination could be hidden by ignore or accessTables after the upload is created
+	if err != nil || !s.accessible(filepath.Join(up.Path, up.Filename)) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return nil
+	}
+	if time.Now().Unix() > up.Expires {
+		s.removeTusUpload(up.ID)
+		http.Error(w, "Upload expired", http.StatusGone)
+		return nil
+	}
+	auth := s.readAccessConf(up.Path)
+	if !auth.canUpload(req) {
+		http.Error(w, "Upload forbidden", http.StatusForbidden)
+		return nil
+	}
+	return up
+}
+
+// hTusHead reports the offset of upload, so client knows where to resume
+func (s *HTTPStaticServer) hTusHead(w http.ResponseWriter, req *http.Request) {
+	up := s.lookupTusUpload(w, req)
+	if up == nil {
+		return
+	}
+	info, err := os.Stat(s.tusDataPath(up.ID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setTusHeaders(w, up)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Size(), 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(up.Length, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// hTusPatch appends request body to the upload at "Upload-Offset"
+func (s *HTTPStaticServer) hTusPatch(w http.ResponseWriter, req *http.Request) {
+	up := s.lookupTusUpload(w, req)
+	if up == nil {
+		return
+	}
+	if req.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type should be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	tusBusyMu.Lock()
+	busy := tusBusy[up.ID]
+	tusBusy[up.ID] = true
+	tusBusyMu.Unlock()
+	if busy {
+		http.Error(w, "Upload is being written by another request", http.StatusLocked)
+		return
+	}
+	defer func() {
+		tusBusyMu.Lock()
+		delete(tusBusy, up.ID)
+		tusBusyMu.Unlock()
+	}()
+
+	dataPath := s.tusDataPath(up.ID)
+	info, err := os.Stat(dataPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	offset, err := strconv.ParseInt(req.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != info.Size() {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(info.Size(), 10))
+		http.Error(w, "Upload-Offset mismatch", http.StatusConflict)
+		return
+	}
+	f, err := os.OpenFile(dataPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// data received before the connection is broken is kept, client resumes from there
+	n, copyErr := io.Copy(f, io.LimitReader(req.Body, up.Length-offset))
+	if err = f.Close(); copyErr == nil {
+		copyErr = err
+	}
+	offset += n
+
+	up.Expires = time.Now().Add(s.UploadExpire).Unix()
+	if err = s.saveTusUpload(up); err == nil && offset == up.Length {
+		err = s.finishTusUpload(up)
+	}
+	if copyErr != nil {
+		log.Println("Handle tus upload:", copyErr)
+		http.Error(w, copyErr.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		log.Println("Handle tus upload:", err)
+		httpError(w, err)
+		return
+	}
+	setTusHeaders(w, up)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// hTusDelete terminates an upload
+func (s *HTTPStaticServer) hTusDelete(w http.ResponseWriter, req *http.Request) {
+	up := s.lookupTusUpload(w, req)
+	if up == nil {
+		return
+	}
+	s.removeTusUpload(up.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishTusUpload moves the completed upload to its destination, the upload is dropped if it conflicts
+func (s *HTTPStaticServer) finishTusUpload(up *tusUpload) error {
+	policy := up.Conflict
+	if policy == "" {
+		policy = conflictOverwrite
+	}
+	fileWriteMu.Lock()
+	var err error
+	if policy == conflictOverwrite {
+		err = s.saveHistory(filepath.Join(up.Path, up.Filename), "upload", up.User, up.IP)
+	}
+	dstPath := ""
+	if err == nil {
+		dstPath, err = placeFile(s.tusDataPath(up.ID), filepath.Join(s.Root, up.Path, up.Filename), policy)
+	}
+	fileWriteMu.Unlock()
+	if err != nil {
+		if _, ok := err.(*statusError); ok {
+			s.removeTusUpload(up.ID)
+		}
+		return err
+	}
+	os.Remove(s.tusInfoPath(up.ID))
+	log.Printf("tus upload %s finished: %s", up.ID, dstPath)
+	return nil
+}
+
+// cleanTusUploads removes uploads which are not finished before expiration
+func (s *HTTPStaticServer) cleanTusUploads() {
+	infos, err := ioutil.ReadDir(s.tusDir())
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, info := range infos {
+		id := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+		up, err := s.loadTusUpload(id)
+		if err != nil && info.ModTime().After(now.Add(-s.UploadExpire)) {
+			continue // info file could be being written
+		}
+		if err != nil || now.Unix() > up.Expires {
+			log.Printf("Remove expired tus upload: %s", id)
+			s.removeTusUpload(id)
+		}
+	}
+}
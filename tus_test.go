@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tusCreate creates an upload of name with length into directory url, and returns the upload url
+func tusCreate(t *testing.T, s *HTTPStaticServer, url, name string, length int) string {
+	req := httptest.NewRequest("POST", url, nil)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Upload-Length", strconv.Itoa(length))
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte(name)))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed: create %s - code:%d %s", url, w.Code, w.Body.String())
+	}
+	return w.Header().Get("Location")
+}
+
+func TestTusUpload(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "d"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "d", "b.txt"), []byte("old"), 0644)
+	s := NewHTTPStaticServer(root)
+	s.Upload = true
+
+	uploads := map[string]string{
+		"a": tusCreate(t, s, "/-/tus/d", "a.txt", 10),
+		"b": tusCreate(t, s, "/-/tus/d?onConflict=rename", "b.txt", 3),
+	}
+	tests := []struct {
+		method string
+		upload string
+		offset string
+		body   string
+		code   int
+		result string // Upload-Offset of response
+	}{
+		{"PATCH", "a", "0", "hello", http.StatusNoContent, "5"},
+		// client resumes from the offset of server
+		{"PATCH", "a", "3", "lo wo", http.StatusConflict, "5"},
+		{"PATCH", "a", "", "world", http.StatusConflict, "5"},
+		{"HEAD", "a", "", "", http.StatusNoContent, "5"},
+		// data beyond Upload-Length is not taken
+		{"PATCH", "a", "5", "world!", http.StatusNoContent, "10"},
+		{"HEAD", "a", "", "", http.StatusNotFound, ""},
+		{"PATCH", "b", "0", "new", http.StatusNoContent, "3"},
+	}
+	for _, v := range tests {
+		req := httptest.NewRequest(v.method, uploads[v.upload], strings.NewReader(v.body))
+		req.Header.Set("Tus-Resumable", tusVersion)
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		if v.offset != "" {
+			req.Header.Set("Upload-Offset", v.offset)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != v.code || w.Header().Get("Upload-Offset") != v.result {
+			t.Fatalf("Failed: %v - code:%d offset:%s %s", v, w.Code, w.Header().Get("Upload-Offset"), w.Body.String())
+		}
+	}
+	for name, content := range map[string]string{"a.txt": "helloworld", "b.txt": "old", "b (1).txt": "new"} {
+		if data, _ := ioutil.ReadFile(filepath.Join(root, "d", name)); string(data) != content {
+			t.Fatalf("Failed: %s - content:%q", name, data)
+		}
+	}
+	if infos, _ := ioutil.ReadDir(s.tusDir()); len(infos) != 0 {
+		t.Fatalf("Failed: %d files left after finish", len(infos))
+	}
+}
+
+func TestTusUploadExpired(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	s := NewHTTPStaticServer(root)
+	s.Upload = true
+
+	location := tusCreate(t, s, "/-/tus/", "a.txt", 10)
+	up, err := s.loadTusUpload(filepath.Base(location))
+	if err != nil {
+		t.Fatal(err)
+	}
+	up.Expires = time.Now().Add(-time.Minute).Unix()
+	s.saveTusUpload(up)
+
+	req := httptest.NewRequest("PATCH", location, strings.NewReader("hello"))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "0")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusGone {
+		t.Fatalf("Failed: code:%d %s", w.Code, w.Body.String())
+	}
+	for _, path := range []string{s.tusInfoPath(up.ID), s.tusDataPath(up.ID), filepath.Join(root, "a.txt")} {
+		if _, err := os.Lstat(path); err == nil {
+			t.Fatalf("Failed: %s should not exist", path)
+		}
+	}
+}
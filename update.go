@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+type UpdateInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	Sha256  string `json:"sha256"`
+	Notes   string `json:"notes,omitempty"`
+	URL     string `json:"url"`
+}
+
+type fileDigest struct {
+	size    int64
+	modTime int64
+	sha256  string
+}
+
+// sha256 of files, hashed again when size or modtime changes
+var (
+	digestCache   = make(map[string]fileDigest)
+	digestCacheMu sync.Mutex
+)
+
+func fileSha256(path string, info os.FileInfo) (string, error) {
+	digestCacheMu.Lock()
+	fd, ok := digestCache[path]
+	digestCacheMu.Unlock()
+	if ok && fd.size == info.Size() && fd.modTime == info.ModTime().UnixNano() {
+		return fd.sha256, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	fd = fileDigest{
+		size:    info.Size(),
+		modTime: info.ModTime().UnixNano(),
+		sha256:  hex.EncodeToString(h.Sum(nil)),
+	}
+	recordSha256(path, fd)
+	return fd.sha256, nil
+}
+
+// recordSha256 keeps digest of file path, so files written by the server are not hashed again
+func recordSha256(path string, fd fileDigest) {
+	digestCacheMu.Lock()
+	digestCache[path] = fd
+	digestCacheMu.Unlock()
+}
+
+// releaseNotes reads sidecar markdown of file, "app-1.2.apk.md" or "app-1.2.md"
+func releaseNotes(path string) string {
+	for _, notesPath := range []string{path + ".md", strings.TrimSuffix(path, filepath.Ext(path)) + ".md"} {
+		if data, err := ioutil.ReadFile(notesPath); err == nil {
+			return string(data)
+		}
+	}
+	return ""
+}
+
+// isUpToDate reports whether the client version "current" needs no update to file name.
+// Besides version, file name and sha256 are accepted as current.
+func isUpToDate(current, name, digest string, version *Version) bool {
+	if current == "" {
+		return false
+	}
+	if current == name || strings.EqualFold(current, digest) {
+		return true
+	}
+	if version == nil {
+		return false
+	}
+	v, err := parseVersion(current)
+	return err == nil && v.Compare(version) >= 0
+}
+
+// check update of checkout directory, respond 204 if "current" is up to date
+func (s *HTTPStaticServer) hCheckUpdate(w http.ResponseWriter, req *http.Request) {
+	path := mux.Vars(req)["path"]
+	relPath := filepath.Join(s.Root, path)
+	auth := s.readAccessConf(path)
+	if !s.checkRead(w, req, path) {
+		return
+	}
+	if !isDir(relPath) {
+		http.Error(w, "Update forbidden: directory not exists "+path, http.StatusForbidden)
+		return
+	}
+	setCheckoutVary(w, auth)
+	fileName, version, err := s.resolveCheckout(req, path, auth)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	filePath := filepath.Join(relPath, fileName)
+	info, err := os.Stat(filePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	digest, err := fileSha256(filePath, info)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	if isUpToDate(req.FormValue("current"), fileName, digest, version) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	ui := &UpdateInfo{
+		Name:    fileName,
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano() / 1e6,
+		Sha256:  digest,
+		Notes:   releaseNotes(filePath),
+		URL:     genURLStr(req, "/"+filepath.ToSlash(filepath.Join(path, fileName))).String(),
+	}
+	if version != nil {
+		ui.Version = version.String()
+	}
+	data, _ := json.Marshal(ui)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckUpdate(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "ch"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "ch", ".ghs.yml"), []byte("checked: app-1.2.0.apk\n"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "ch", "app-1.2.0.apk"), []byte("app"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "ch", "app-1.2.0.apk.md"), []byte("fixes"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "ch", "app-1.3.0.apk"), []byte("new"), 0644)
+	sum := sha256.Sum256([]byte("app"))
+	digest := hex.EncodeToString(sum[:])
+	s := NewHTTPStaticServer(root)
+
+	tests := []struct {
+		url  string
+		code int
+	}{
+		{"/-/update/ch", http.StatusOK},
+		{"/-/update/ch?current=1.1.0", http.StatusOK},
+		{"/-/update/ch?current=garbage", http.StatusOK},
+		{"/-/update/ch?current=1.2.0", http.StatusNoContent},
+		// a newer client than the checked file is not downgraded
+		{"/-/update/ch?current=1.3.0", http.StatusNoContent},
+		{"/-/update/ch?current=app-1.2.0.apk", http.StatusNoContent},
+		{"/-/update/ch?current=" + digest, http.StatusNoContent},
+		{"/-/update/nodir", http.StatusForbidden},
+	}
+	for _, v := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", v.url, nil))
+		if w.Code != v.code {
+			t.Fatalf("Failed: %v - code:%d %s", v, w.Code, w.Body.String())
+		}
+		if w.Code == http.StatusNoContent && w.Body.Len() != 0 {
+			t.Fatalf("Failed: %v - body:%s", v, w.Body.String())
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var ui UpdateInfo
+		if err := json.Unmarshal(w.Body.Bytes(), &ui); err != nil {
+			t.Fatalf("Failed: %v - %v", v, err)
+		}
+		expect := UpdateInfo{
+			Name:    "app-1.2.0.apk",
+			Version: "1.2.0",
+			Size:    3,
+			ModTime: ui.ModTime,
+			Sha256:  digest,
+			Notes:   "fixes",
+			URL:     "http://example.com/ch/app-1.2.0.apk",
+		}
+		if ui != expect {
+			t.Fatalf("Failed: %v - %+v", v, ui)
+		}
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// uploadTempPrefix is the name prefix of files being uploaded, they are not visible to checkout
+const uploadTempPrefix = ".ghs-upload-"
+
+// Checksums of uploaded file, in hex
+type Checksums struct {
+	MD5    string `json:"md5"`
+	Sha256 string `json:"sha256"`
+}
+
+// expectedChecksums are given by client, nil fields are not verified
+type expectedChecksums struct {
+	md5    []byte
+	sha256 []byte
+}
+
+// decodeDigest accepts both hex and base64 encoded digest of size bytes
+func decodeDigest(s string, size int) ([]byte, bool) {
+	if data, err := hex.DecodeString(s); err == nil && len(data) == size {
+		return data, true
+	}
+	if data, err := base64.StdEncoding.DecodeString(s); err == nil && len(data) == size {
+		return data, true
+	}
+	return nil, false
+}
+
+// parseExpectedChecksums reads "Content-MD5" and "X-Checksum-Sha256" from headers, the first one found is used
+func parseExpectedChecksums(headers ...http.Header) (expect expectedChecksums, err error) {
+	for _, h := range headers {
+		if v := strings.TrimSpace(h.Get("Content-MD5")); v != "" && expect.md5 == nil {
+			var ok bool
+			if expect.md5, ok = decodeDigest(v, md5.Size); !ok {
+				return expect, newStatusError(http.StatusBadRequest, "Invalid Content-MD5: %s", v)
+			}
+		}
+		if v := strings.TrimSpace(h.Get("X-Checksum-Sha256")); v != "" && expect.sha256 == nil {
+			var ok bool
+			if expect.sha256, ok = decodeDigest(v, sha256.Size); !ok {
+				return expect, newStatusError(http.StatusBadRequest, "Invalid X-Checksum-Sha256: %s", v)
+			}
+		}
+	}
+	return
+}
+
+// atomicFile is written to a temp file in the destination directory and renamed into place by Commit,
+// so readers never see a partially written file.
+type atomicFile struct {
+	file   *os.File
+	md5    hash.Hash
+	sha256 hash.Hash
+	w      io.Writer
+}
+
+func newAtomicFile(dir string) (*atomicFile, error) {
+	file, err := ioutil.TempFile(dir, uploadTempPrefix)
+	if err != nil {
+		return nil, err
+	}
+	f := &atomicFile{
+		file:   file,
+		md5:    md5.New(),
+		sha256: sha256.New(),
+	}
+	f.w = io.MultiWriter(file, f.md5, f.sha256)
+	return f, nil
+}
+
+func (f *atomicFile) Write(p []byte) (int, error) {
+	return f.w.Write(p)
+}
+
+func (f *atomicFile) Checksums() Checksums {
+	return Checksums{
+		MD5:    hex.EncodeToString(f.md5.Sum(nil)),
+		Sha256: hex.EncodeToString(f.sha256.Sum(nil)),
+	}
+}
+
+// Verify compares the written content with checksums given by client
+func (f *atomicFile) Verify(expect expectedChecksums) error {
+	if expect.md5 != nil && !bytes.Equal(expect.md5, f.md5.Sum(nil)) {
+		return newStatusError(http.StatusBadRequest, "Checksum mismatch: md5 of content is %s", hex.EncodeToString(f.md5.Sum(nil)))
+	}
+	if expect.sha256 != nil && !bytes.Equal(expect.sha256, f.sha256.Sum(nil)) {
+		return newStatusError(http.StatusBadRequest, "Checksum mismatch: sha256 of content is %s", hex.EncodeToString(f.sha256.Sum(nil)))
+	}
+	return nil
+}
+
+// Commit moves the file to dstPath following conflict policy, and returns the path finally stored.
+// The temp file is removed if anything fails.
+func (f *atomicFile) Commit(dstPath, policy string) (string, error) {
+	err := f.file.Close()
+	if err == nil {
+		err = os.Chmod(f.file.Name(), 0644)
+	}
+	if err == nil {
+		dstPath, err = placeFile(f.file.Name(), dstPath, policy)
+	}
+	if err != nil {
+		os.Remove(f.file.Name())
+		return dstPath, err
+	}
+	if info, err := os.Stat(dstPath); err == nil {
+		recordSha256(dstPath, fileDigest{info.Size(), info.ModTime().UnixNano(), hex.EncodeToString(f.sha256.Sum(nil))})
+	}
+	return dstPath, nil
+}
+
+// Abort removes the temp file
+func (f *atomicFile) Abort() {
+	f.file.Close()
+	os.Remove(f.file.Name())
+}
+
+// policies when upload destination already exists, ordered from the least destructive
+const (
+	conflictReject    = "reject"         // respond 409
+	conflictRename    = "rename"         // store as "foo (1).apk"
+	conflictVersion   = "version-suffix" // store as "foo-20170102-150405.apk"
+	conflictOverwrite = "overwrite"
+)
+
+// conflictLevel returns how destructive policy is, -1 for unknown policy
+func conflictLevel(policy string) int {
+	switch policy {
+	case conflictReject:
+		return 0
+	case conflictRename, conflictVersion:
+		return 1
+	case conflictOverwrite:
+		return 2
+	}
+	return -1
+}
+
+// requestedConflict returns the policy asked by "onConflict" query or "X-On-Conflict" header
+func requestedConflict(req *http.Request) string {
+	// not a form value, which would consume a streamed multipart body
+	if policy := req.URL.Query().Get("onConflict"); policy != "" {
+		return policy
+	}
+	return req.Header.Get("X-On-Conflict")
+}
+
+// requestConflictPolicy returns the policy asked by request, see conflictPolicy
+func requestConflictPolicy(req *http.Request, auth AccessConf) (string, error) {
+	return conflictPolicy(requestedConflict(req), auth)
+}
+
+// conflictPolicy returns the policy of directory when policy is empty.
+// A requested policy could not be more destructive than the directory's.
+func conflictPolicy(policy string, auth AccessConf) (string, error) {
+	allowed := auth.OnConflict
+	if allowed == "" {
+		allowed = conflictOverwrite
+	}
+	if conflictLevel(allowed) < 0 {
+		return "", newStatusError(http.StatusInternalServerError, "Invalid onConflict in .ghs.yml: %s", allowed)
+	}
+	if policy == "" {
+		return allowed, nil
+	}
+	if conflictLevel(policy) < 0 {
+		return "", newStatusError(http.StatusBadRequest, "Invalid onConflict: %s", policy)
+	}
+	if conflictLevel(policy) > conflictLevel(allowed) {
+		return "", newStatusError(http.StatusForbidden, "onConflict %s is not allowed, directory allows %s", policy, allowed)
+	}
+	return policy, nil
+}
+
+// checkConflict fails early for reject policy, before the upload content is received
+func checkConflict(dstPath, policy string) error {
+	if policy == conflictReject {
+		if _, err := os.Lstat(dstPath); err == nil {
+			return newStatusError(http.StatusConflict, "File already exists: %s", filepath.Base(dstPath))
+		}
+	}
+	return nil
+}
+
+// splitExt splits "foo.tar.gz" to "foo" and ".tar.gz"
+func splitExt(name string) (string, string) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if filepath.Ext(base) == ".tar" {
+		ext = ".tar" + ext
+		base = strings.TrimSuffix(base, ".tar")
+	}
+	return base, ext
+}
+
+// conflictName returns the i-th name tried for dstPath by rename and version-suffix policy, the first is dstPath
+func conflictName(dstPath, policy string, i int, now time.Time) string {
+	dir, name := filepath.Split(dstPath)
+	base, ext := splitExt(name)
+	suffix := now.Format("20060102-150405")
+	switch {
+	case policy == conflictRename && i > 0:
+		return filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+	case policy == conflictVersion && i == 1:
+		return filepath.Join(dir, base+"-"+suffix+ext)
+	case policy == conflictVersion && i > 1:
+		return filepath.Join(dir, fmt.Sprintf("%s-%s-%d%s", base, suffix, i-1, ext))
+	}
+	return dstPath
+}
+
+// placeFile moves srcPath to dstPath following the conflict policy, and returns the path finally stored.
+// Except overwrite, existing files are never replaced, even if one is created concurrently.
+func placeFile(srcPath, dstPath, policy string) (string, error) {
+	if isAccessConfFile(dstPath) {
+		defer invalidateAccessConf()
+	}
+	if policy == conflictOverwrite {
+		return dstPath, os.Rename(srcPath, dstPath)
+	}
+	name := filepath.Base(dstPath)
+	now := time.Now()
+	for i := 0; i < 1000; i++ {
+		candidate := conflictName(dstPath, policy, i, now)
+		// link fails when the destination exists, which rename does not
+		err := os.Link(srcPath, candidate)
+		if err == nil {
+			os.Remove(srcPath)
+			return candidate, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+		if policy == conflictReject {
+			return "", newStatusError(http.StatusConflict, "File already exists: %s", name)
+		}
+	}
+	return "", newStatusError(http.StatusConflict, "Too many files named like %s", name)
+}
+
+// UploadResult is the result of one file in an upload request
+type UploadResult struct {
+	Name        string       `json:"name"` // relative path given by client
+	Success     bool         `json:"success"`
+	Destination string       `json:"destination,omitempty"`
+	MD5         string       `json:"md5,omitempty"`
+	Sha256      string       `json:"sha256,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	Current     *FileVersion `json:"current,omitempty"` // when precondition failed
+	code        int
+}
+
+// partFileName returns the file name of part as sent by client.
+// Unlike part.FileName(), directories in it are kept, e.g. curl -F "file=@a;filename=sub/a"
+func partFileName(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	return params["filename"]
+}
+
+// uploadRelativePath cleans a file path given by client, which must stay inside the upload directory
+func uploadRelativePath(name string) (string, error) {
+	rel := sanitizedName(name)
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", newStatusError(http.StatusBadRequest, "Invalid file name: %s", name)
+	}
+	for _, elem := range strings.Split(rel, "/") {
+		if elem == internalDir {
+			return "", newStatusError(http.StatusForbidden, "Invalid file name: %s", name)
+		}
+	}
+	return rel, nil
+}
+
+// saveUploadPart stores one file of upload request into directory path, intermediate directories are
+// created if the user could mkdir. Checksums expected by client and preconditions are read from headers.
+// If ready is not nil, the file is only stored when it returns no error after the content is received.
+func (s *HTTPStaticServer) saveUploadPart(req *http.Request, path, name string, r io.Reader, headers []http.Header, ready func() error) (result UploadResult) {
+	result.Name = name
+	dstPath, sums, err := s.storeUploadPart(req, path, name, r, headers, ready)
+	if err != nil {
+		log.Println("Handle upload file:", err)
+		result.Error, result.code = err.Error(), http.StatusInternalServerError
+		switch e := err.(type) {
+		case *statusError:
+			result.Error, result.code = e.Message, e.Code
+		case *preconditionError:
+			result.code, result.Current = http.StatusPreconditionFailed, e.Current
+		}
+		return
+	}
+	result.Success = true
+	result.Destination = dstPath
+	result.MD5 = sums.MD5
+	result.Sha256 = sums.Sha256
+	return
+}
+
+// existingParent returns the nearest existing directory above path (from root), whose conf decides
+// creating the missing ones. A file in the way is reported as conflict.
+func (s *HTTPStaticServer) existingParent(path string) (string, error) {
+	existing := filepath.Dir(path)
+	for !isDir(filepath.Join(s.Root, existing)) {
+		if _, err := os.Lstat(filepath.Join(s.Root, existing)); err == nil {
+			return "", newStatusError(http.StatusConflict, "Not a directory: %s", filepath.ToSlash(existing))
+		}
+		existing = filepath.Dir(existing)
+	}
+	return existing, nil
+}
+
+// fileHeaderKeys are headers of checksums and preconditions, which are about the content of a single file
+var fileHeaderKeys = []string{"Content-MD5", "X-Checksum-Sha256", "If-Match", "If-None-Match", "If-Unmodified-Since"}
+
+func hasFileHeaders(h http.Header) bool {
+	for _, key := range fileHeaderKeys {
+		if h.Get(key) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareUploadDir checks upload permission of subPath under path, and creates it if the user could mkdir
+// in the nearest existing directory
+func (s *HTTPStaticServer) prepareUploadDir(req *http.Request, path, subPath string) (AccessConf, error) {
+	auth := s.readAccessConf(subPath)
+	if !auth.canUpload(req) {
+		return auth, newStatusError(http.StatusForbidden, "Upload forbidden: %s", filepath.ToSlash(subPath))
+	}
+	dirpath := filepath.Join(s.Root, subPath)
+	if isDir(dirpath) {
+		return auth, nil
+	}
+	if subPath == path {
+		return auth, newStatusError(http.StatusForbidden, "Upload forbidden: directory not exists %s", filepath.ToSlash(subPath))
+	}
+	existing, err := s.existingParent(subPath)
+	if err != nil {
+		return auth, err
+	}
+	if parent := s.readAccessConf(existing); !parent.canMKDir(req) {
+		return auth, newStatusError(http.StatusForbidden, "Upload forbidden: directory not exists %s", filepath.ToSlash(subPath))
+	}
+	if err := os.MkdirAll(dirpath, 0755); err != nil {
+		return auth, newStatusError(http.StatusConflict, "Create directory %s failed: %v", filepath.ToSlash(subPath), err)
+	}
+	return auth, nil
+}
+
+func (s *HTTPStaticServer) storeUploadPart(req *http.Request, path, name string, r io.Reader, headers []http.Header, ready func() error) (string, Checksums, error) {
+	rel, err := uploadRelativePath(name)
+	if err != nil {
+		return "", Checksums{}, err
+	}
+	if !s.accessible(filepath.Join(path, rel)) {
+		return "", Checksums{}, newStatusError(http.StatusNotFound, "Not found: %s", filepath.ToSlash(rel))
+	}
+	if err = s.checkAccessConfWrite(req, rel); err != nil {
+		return "", Checksums{}, err
+	}
+	subPath := filepath.Join(path, filepath.Dir(rel))
+	auth, err := s.prepareUploadDir(req, path, subPath)
+	if err != nil {
+		return "", Checksums{}, err
+	}
+	policy, err := requestConflictPolicy(req, auth)
+	if err != nil {
+		return "", Checksums{}, err
+	}
+	expect, err := parseExpectedChecksums(headers...)
+	if err != nil {
+		return "", Checksums{}, err
+	}
+	dirpath := filepath.Join(s.Root, subPath)
+	dstPath := filepath.Join(dirpath, filepath.Base(rel))
+	if err := checkConflict(dstPath, policy); err != nil {
+		return "", Checksums{}, err
+	}
+	if err := checkPreconditions(dstPath, headers...); err != nil {
+		return "", Checksums{}, err
+	}
+	// write to a temp file first, so the destination is never seen half written
+	dst, err := newAtomicFile(dirpath)
+	if err != nil {
+		return "", Checksums{}, err
+	}
+	if _, err := io.Copy(dst, r); err != nil {
+		dst.Abort()
+		return "", Checksums{}, err
+	}
+	if err := dst.Verify(expect); err != nil {
+		dst.Abort()
+		return "", Checksums{}, err
+	}
+	if ready != nil {
+		if err := ready(); err != nil {
+			dst.Abort()
+			return "", Checksums{}, err
+		}
+	}
+	// file could be changed while receiving, preconditions are checked again
+	fileWriteMu.Lock()
+	err = checkPreconditions(dstPath, headers...)
+	if err == nil && policy == conflictOverwrite {
+		user, ip := requestAuthor(req)
+		err = s.saveHistory(filepath.Join(subPath, filepath.Base(rel)), "upload", user, ip)
+	}
+	if err != nil {
+		dst.Abort()
+	} else {
+		dstPath, err = dst.Commit(dstPath, policy)
+	}
+	fileWriteMu.Unlock()
+	if err != nil {
+		return "", Checksums{}, err
+	}
+	return dstPath, dst.Checksums(), nil
+}
+
+// uploadResponse is the JSON of upload results, status code is the code of the first failure
+func uploadResponse(results []UploadResult) (map[string]interface{}, int) {
+	ret := map[string]interface{}{
+		"success": true,
+		"files":   results,
+	}
+	code := http.StatusOK
+	for _, result := range results {
+		if !result.Success {
+			ret["success"] = false
+			ret["error"] = result.Name + ": " + result.Error
+			code = result.code
+			break
+		}
+	}
+	// fields of single file upload
+	if len(results) == 1 && results[0].Success {
+		ret["destination"] = results[0].Destination
+		ret["md5"] = results[0].MD5
+		ret["sha256"] = results[0].Sha256
+	}
+	return ret, code
+}
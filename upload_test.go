@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestUploadMultipartChecksums(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	s := NewHTTPStaticServer(root)
+	s.Upload = true
+	sum := func(data string) string {
+		h := sha256.Sum256([]byte(data))
+		return hex.EncodeToString(h[:])
+	}
+	type part struct {
+		name, data, sha256 string
+	}
+	ioutil.WriteFile(filepath.Join(root, "old.txt"), []byte("old"), 0644)
+	etag, _ := fileETag(filepath.Join(root, "old.txt"))
+	tests := []struct {
+		parts   []part
+		header  string // header of the request
+		value   string
+		code    int
+		content string // of the last part after upload
+	}{
+		{[]part{{"a.txt", "a", sum("a")}, {"b.txt", "b", sum("b")}}, "", "", http.StatusOK, "b"},
+		{[]part{{"a.txt", "x", sum("x")}, {"b.txt", "y", sum("x")}}, "", "", http.StatusBadRequest, "b"},
+		// headers of the request apply to a single file, several files are refused before any is stored
+		{[]part{{"c.txt", "c", ""}}, "X-Checksum-Sha256", sum("c"), http.StatusOK, "c"},
+		{[]part{{"c.txt", "z", ""}}, "X-Checksum-Sha256", sum("c"), http.StatusBadRequest, "c"},
+		{[]part{{"c.txt", "z", sum("z")}}, "X-Checksum-Sha256", sum("c"), http.StatusOK, "z"},
+		{[]part{{"d.txt", "d", ""}, {"e.txt", "e", ""}}, "X-Checksum-Sha256", sum("d"), http.StatusBadRequest, ""},
+		{[]part{{"d.txt", "d", ""}, {"e.txt", "e", ""}}, "Content-MD5", "AAAAAAAAAAAAAAAAAAAAAA==", http.StatusBadRequest, ""},
+		{[]part{{"old.txt", "new", ""}}, "If-Match", `"stale"`, http.StatusPreconditionFailed, "old"},
+		{[]part{{"old.txt", "new", ""}}, "If-Match", etag, http.StatusOK, "new"},
+	}
+	for _, v := range tests {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		for _, p := range v.parts {
+			h := textproto.MIMEHeader{}
+			h.Set("Content-Disposition", `form-data; name="file"; filename="`+p.name+`"`)
+			if p.sha256 != "" {
+				h.Set("X-Checksum-Sha256", p.sha256)
+			}
+			w, _ := mw.CreatePart(h)
+			w.Write([]byte(p.data))
+		}
+		mw.Close()
+		req := httptest.NewRequest("POST", "/", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		if v.header != "" {
+			req.Header.Set(v.header, v.value)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != v.code {
+			t.Fatalf("Failed: %v - code:%d %s", v, w.Code, w.Body.String())
+		}
+		last := v.parts[len(v.parts)-1]
+		if data, _ := ioutil.ReadFile(filepath.Join(root, last.name)); string(data) != v.content {
+			t.Fatalf("Failed: %v - content:%q", v, data)
+		}
+		if len(v.parts) > 1 && v.header != "" && isFile(filepath.Join(root, v.parts[0].name)) {
+			t.Fatalf("Failed: %v - first file stored", v)
+		}
+	}
+}
+
+func TestUploadParentDirs(t *testing.T) {
+	root, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "a"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "a", ".ghs.yml"), []byte("mkdir: false\n"), 0644)
+	s := NewHTTPStaticServer(root)
+	s.Upload, s.MKDir = true, true
+
+	tests := []struct {
+		url     string
+		name    string // file uploaded, or directory extracted if ends with "/"
+		code    int
+		created string
+	}{
+		// missing directories are decided by mkdir of the nearest existing one, not of the upload root
+		{"/", "a/new/f.txt", http.StatusForbidden, ""},
+		{"/-/extract/?format=tar", "a/new/", http.StatusForbidden, ""},
+		{"/", "b/new/f.txt", http.StatusOK, "b/new/f.txt"},
+		{"/-/extract/?format=tar", "c/new/", http.StatusOK, "c/new"},
+		{"/a", "f.txt", http.StatusOK, "a/f.txt"},
+		{"/", "b/new/f.txt/x", http.StatusConflict, ""},
+	}
+	for _, v := range tests {
+		var req *http.Request
+		if v.url == "/" || v.url == "/a" {
+			body := &bytes.Buffer{}
+			mw := multipart.NewWriter(body)
+			w, _ := mw.CreateFormFile("file", v.name)
+			w.Write([]byte("f"))
+			mw.Close()
+			req = httptest.NewRequest("POST", v.url, body)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+		} else {
+			req = httptest.NewRequest("POST", v.url, bytes.NewReader(makeTar([]archiveEntry{{v.name, "dir"}})))
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != v.code {
+			t.Fatalf("Failed: %v - code:%d %s", v, w.Code, w.Body.String())
+		}
+		if v.created != "" {
+			if _, err := os.Stat(filepath.Join(root, v.created)); err != nil {
+				t.Fatalf("Failed: %v - %s not created", v, v.created)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(root, "a", "new")); err == nil {
+			t.Fatalf("Failed: %v - a/new created", v)
+		}
+	}
+}
+
+func TestConflictPolicy(t *testing.T) {
+	tests := []struct {
+		requested string
+		allowed   string
+		policy    string
+		code      int
+	}{
+		{"", "", conflictOverwrite, 0},
+		{"", conflictRename, conflictRename, 0},
+		{conflictReject, "", conflictReject, 0},
+		{conflictVersion, conflictRename, conflictVersion, 0},
+		// not more destructive than the directory allows
+		{conflictOverwrite, conflictRename, "", http.StatusForbidden},
+		{conflictRename, conflictReject, "", http.StatusForbidden},
+		{"replace", "", "", http.StatusBadRequest},
+		{"", "replace", "", http.StatusInternalServerError},
+	}
+	for _, v := range tests {
+		policy, err := conflictPolicy(v.requested, AccessConf{OnConflict: v.allowed})
+		code := 0
+		if e, ok := err.(*statusError); ok {
+			code = e.Code
+		}
+		if policy != v.policy || code != v.code {
+			t.Fatalf("Failed: %v - res:%s %v", v, policy, err)
+		}
+	}
+}
+
+func TestPlaceFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ghs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name   string
+		policy string
+		stored string // regexp of the name finally stored, empty if rejected
+	}{
+		{"a.apk", conflictRename, `^a\.apk$`},
+		{"a.apk", conflictRename, `^a \(1\)\.apk$`},
+		{"a.apk", conflictRename, `^a \(2\)\.apk$`},
+		{"a.apk", conflictReject, ``},
+		{"a.apk", conflictVersion, `^a-\d{8}-\d{6}\.apk$`},
+		{"b.tar.gz", conflictRename, `^b\.tar\.gz$`},
+		{"b.tar.gz", conflictRename, `^b \(1\)\.tar\.gz$`},
+		{"a.apk", conflictOverwrite, `^a\.apk$`},
+	}
+	for i, v := range tests {
+		src := filepath.Join(dir, "src")
+		content := fmt.Sprint(i)
+		ioutil.WriteFile(src, []byte(content), 0644)
+		stored, err := placeFile(src, filepath.Join(dir, v.name), v.policy)
+		if v.stored == "" {
+			if e, ok := err.(*statusError); !ok || e.Code != http.StatusConflict {
+				t.Fatalf("Failed: %v - err:%v", v, err)
+			}
+			os.Remove(src)
+			continue
+		}
+		if err != nil || !regexp.MustCompile(v.stored).MatchString(filepath.Base(stored)) {
+			t.Fatalf("Failed: %v - stored:%s %v", v, stored, err)
+		}
+		if data, _ := ioutil.ReadFile(stored); string(data) != content {
+			t.Fatalf("Failed: %v - content:%q", v, data)
+		}
+	}
+	// names tried after the first version suffix is taken
+	at := time.Date(2017, 1, 2, 15, 4, 5, 0, time.Local)
+	if name := conflictName(filepath.Join(dir, "a.apk"), conflictVersion, 2, at); filepath.Base(name) != "a-20170102-150405-1.apk" {
+		t.Fatalf("Failed: version suffix %s", name)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 )
@@ -21,6 +22,33 @@ import (
 // 	return ""
 // }
 
+// statusError is an error which should be responded with a specific http status code
+type statusError struct {
+	Code    int
+	Message string
+}
+
+func (e *statusError) Error() string {
+	return e.Message
+}
+
+func newStatusError(code int, format string, args ...interface{}) error {
+	return &statusError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// httpError writes err to w, status code is 500 unless err is a *statusError
+func httpError(w http.ResponseWriter, err error) {
+	switch e := err.(type) {
+	case *statusError:
+		http.Error(w, e.Message, e.Code)
+		return
+	case *preconditionError:
+		writePreconditionFailed(w, e)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
 func getRealIP(req *http.Request) string {
 	xip := req.Header.Get("X-Real-IP")
 	if xip == "" {
@@ -79,7 +79,8 @@ func (z *Zip) Add(relpath, abspath string) error {
 	return err
 }
 
-func CompressToZip(w http.ResponseWriter, rootDir string) {
+// CompressToZip writes rootDir as zip to w, files and directories are skipped when filter returns false
+func CompressToZip(w http.ResponseWriter, rootDir string, filter func(path string, info os.FileInfo) bool) {
 	rootDir = filepath.Clean(rootDir)
 	zipFileName := filepath.Base(rootDir) + ".zip"
 
@@ -90,6 +91,15 @@ func CompressToZip(w http.ResponseWriter, rootDir string) {
 	defer zw.Close()
 
 	filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if filter != nil && !filter(path, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		zipPath := path[len(rootDir):]
 		return zw.Add(zipPath, path)
 	})